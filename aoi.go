@@ -1,8 +1,39 @@
 package aoi
 
-// 位置
+// 位置，z为高度，只在开启垂直范围时参与可见性判断
 type Position struct {
-	x, y float32
+	x, y, z float32
+}
+
+// 创建位置
+func NewPosition(x, y float32) Position {
+	return Position{x: x, y: y}
+}
+
+// 创建带高度的位置
+func NewPosition3D(x, y, z float32) Position {
+	return Position{x: x, y: y, z: z}
+}
+
+// x坐标
+func (p Position) X() float32 {
+	return p.x
+}
+
+// y坐标
+func (p Position) Y() float32 {
+	return p.y
+}
+
+// 高度
+func (p Position) Z() float32 {
+	return p.z
+}
+
+// 两点距离的平方
+func distSq(a, b Position) float32 {
+	dx, dy := a.x-b.x, a.y-b.y
+	return dx*dx + dy*dy
 }
 
 // 实体
@@ -14,75 +45,344 @@ type Entity interface {
 	OnLeaveAOI(other Entity)
 }
 
-// 网格
-type Grid struct {
-	entitys  map[int]Entity // 网格中的实体
-	watchers map[int]Entity // 观察者实体
+// AOI接口
+type AOI interface {
+	Enter(entity Entity, pos Position) // 进入地图
+	Leave(entity Entity)               // 离开地图
+	Move(entity Entity, pos Position)  // 移动
+	Watching(entity Entity) []Entity   // 获取视野内的实体
+	Watchers(entity Entity) []Entity   // 获取能看到实体的观察者
 }
 
-// 初始化
-func (g *Grid) init() {
-	g.entitys = make(map[int]Entity)
-	g.watchers = make(map[int]Entity)
+// 可选接口，视野内的实体移动时通知观察者
+type MoveWatcher interface {
+	OnMoveAOI(other Entity, from, to Position)
 }
 
-// 添加实体
-func (g *Grid) addEntity(entity Entity) {
-	g.entitys[entity.ID()] = entity
+// 视野变化原因
+type Reason int
+
+const (
+	ReasonEnter    Reason = iota // 进入地图
+	ReasonLeave                  // 离开地图
+	ReasonMove                   // 移动
+	ReasonTeleport               // 传送
+	ReasonRefresh                // 可见性判断条件变化
+)
+
+// 可选接口，带原因的进出视野回调，实现后代替OnEnterAOI和OnLeaveAOI
+type ReasonWatcher interface {
+	OnEnterAOIReason(other Entity, reason Reason)
+	OnLeaveAOIReason(other Entity, reason Reason)
 }
 
-// 移除实体
-func (g *Grid) removeEntity(entity Entity) {
-	delete(g.entitys, entity.ID())
+// 回调进入视野
+func enterAOI(watcher, other Entity, reason Reason) {
+	if rw, ok := watcher.(ReasonWatcher); ok {
+		rw.OnEnterAOIReason(other, reason)
+		return
+	}
+	watcher.OnEnterAOI(other)
 }
 
-// 添加观察者
-func (g *Grid) addWatcher(entity Entity) {
-	if _, ok := g.watchers[entity.ID()]; ok {
+// 回调离开视野
+func leaveAOI(watcher, other Entity, reason Reason) {
+	if rw, ok := watcher.(ReasonWatcher); ok {
+		rw.OnLeaveAOIReason(other, reason)
 		return
 	}
-	for _, other := range g.entitys {
-		if entity.ID() != other.ID() {
-			entity.OnEnterAOI(other)
-		}
+	watcher.OnLeaveAOI(other)
+}
+
+// 回调通知
+type notifier interface {
+	notifyEnter(w, n *aoiNode)                   // 实体进入观察者视野
+	notifyLeave(w, n *aoiNode)                   // 实体离开观察者视野
+	notifyMove(w, n *aoiNode, from, to Position) // 实体在观察者视野内移动
+}
+
+// 视野判断和回调通知，由各AOI实现提供
+type viewer interface {
+	notifier
+	canSee(w, n *aoiNode) bool
+}
+
+// 直接回调实体
+type directNotifier struct{}
+
+func (directNotifier) notifyEnter(w, n *aoiNode) {
+	w.entity.OnEnterAOI(n.entity)
+}
+
+func (directNotifier) notifyLeave(w, n *aoiNode) {
+	w.entity.OnLeaveAOI(n.entity)
+}
+
+func (directNotifier) notifyMove(w, n *aoiNode, from, to Position) {
+	if mw, ok := w.entity.(MoveWatcher); ok {
+		mw.OnMoveAOI(n.entity, from, to)
 	}
-	g.watchers[entity.ID()] = entity
 }
 
-// 移除观察者
-func (g *Grid) removeWatcher(entity Entity) {
-	if _, ok := g.watchers[entity.ID()]; !ok {
+// 可选接口，实体自定义视野范围
+type ViewRanger interface {
+	ViewRange() float32
+}
+
+// 获取实体的视野范围，未实现ViewRanger时使用默认值
+func viewRangeOf(entity Entity, def float32) float32 {
+	if v, ok := entity.(ViewRanger); ok && v.ViewRange() > 0 {
+		return v.ViewRange()
+	}
+	return def
+}
+
+// 视野范围统计，用于确定查找候选观察者的范围
+type viewRanges struct {
+	counts map[float32]int // 各视野范围的实体数量
+	max    float32         // 最大视野范围
+}
+
+// 添加视野范围
+func (v *viewRanges) add(r float32) {
+	if v.counts == nil {
+		v.counts = make(map[float32]int)
+	}
+	v.counts[r]++
+	if r > v.max {
+		v.max = r
+	}
+}
+
+// 移除视野范围，必要时重新计算最大视野范围
+func (v *viewRanges) remove(r float32) {
+	v.counts[r]--
+	if v.counts[r] > 0 {
+		return
+	}
+	delete(v.counts, r)
+	if r < v.max {
 		return
 	}
-	for _, other := range g.entitys {
-		if entity.ID() != other.ID() {
-			entity.OnLeaveAOI(other)
+	v.max = 0
+	for r := range v.counts {
+		if r > v.max {
+			v.max = r
+		}
+	}
+}
+
+// 进入模式
+type Mode int
+
+const (
+	ModeWatcher Mode = 1 << iota // 只观察，不被看到，如观战镜头
+	ModeWatched                  // 只被观察，不观察其他实体，如NPC、陷阱、掉落物
+)
+
+// 既观察也被观察
+const ModeBoth = ModeWatcher | ModeWatched
+
+// 实体节点
+type aoiNode struct {
+	entity                 Entity
+	mode                   Mode             // 进入模式
+	pos                    Position         // 在地图中的坐标
+	ghost                  bool             // 分片边界的镜像，不参与区域
+	viewRange              float32          // 视野范围
+	xmin, xmax, ymin, ymax int              // 观察的网格范围
+	watching               map[int]*aoiNode // 视野内的实体
+	watchers               map[int]*aoiNode // 能看到自己的观察者
+	regions                map[int]*Region  // 所在的区域
+	tiers                  map[int]Tier     // 视野内实体的层级
+}
+
+// 创建实体节点
+func newAOINode(entity Entity) *aoiNode {
+	return &aoiNode{
+		entity:   entity,
+		mode:     ModeBoth,
+		xmax:     -1,
+		ymax:     -1,
+		watching: make(map[int]*aoiNode),
+		watchers: make(map[int]*aoiNode),
+	}
+}
+
+// 加入视野
+func (n *aoiNode) watch(nt notifier, other *aoiNode) {
+	n.watching[other.entity.ID()] = other
+	other.watchers[n.entity.ID()] = n
+	nt.notifyEnter(n, other)
+}
+
+// 移出视野
+func (n *aoiNode) unwatch(nt notifier, other *aoiNode) {
+	delete(n.watching, other.entity.ID())
+	delete(other.watchers, n.entity.ID())
+	nt.notifyLeave(n, other)
+}
+
+// 移除所有视野关系，先通知观察者，再通知自己
+func (n *aoiNode) clear(nt notifier) {
+	for _, w := range n.watchers {
+		w.unwatch(nt, n)
+	}
+	for _, other := range n.watching {
+		n.unwatch(nt, other)
+	}
+}
+
+// 更新视野，visit遍历候选实体
+func (n *aoiNode) updateWatching(v viewer, visit func(func(*aoiNode))) {
+	// 离开视野
+	for _, other := range n.watching {
+		if !v.canSee(n, other) {
+			n.unwatch(v, other)
+		}
+	}
+	// 进入视野
+	visit(func(other *aoiNode) {
+		if _, ok := n.watching[other.entity.ID()]; ok || other == n || !v.canSee(n, other) {
+			return
+		}
+		n.watch(v, other)
+	})
+}
+
+// 更新能看到实体的观察者，visit遍历候选观察者
+func (n *aoiNode) updateWatchers(v viewer, visit func(func(*aoiNode)), fromPos, toPos Position) {
+	for _, w := range n.watchers {
+		if v.canSee(w, n) {
+			if fromPos != toPos {
+				v.notifyMove(w, n, fromPos, toPos)
+			}
+		} else {
+			w.unwatch(v, n)
+		}
+	}
+	visit(func(w *aoiNode) {
+		if _, ok := n.watchers[w.entity.ID()]; ok || w == n || !v.canSee(w, n) {
+			return
 		}
+		w.watch(v, n)
+	})
+}
+
+// 视野内的实体列表
+func (n *aoiNode) watchingList() []Entity {
+	list := make([]Entity, 0, len(n.watching))
+	for _, other := range n.watching {
+		list = append(list, other.entity)
+	}
+	return list
+}
+
+// 观察者列表
+func (n *aoiNode) watcherList() []Entity {
+	list := make([]Entity, 0, len(n.watchers))
+	for _, w := range n.watchers {
+		list = append(list, w.entity)
 	}
-	delete(g.watchers, entity.ID())
+	return list
+}
+
+// 网格
+type Grid struct {
+	entitys  map[int]*aoiNode // 网格中的实体
+	watchers map[int]*aoiNode // 观察网格的实体
+	regions  map[int]*Region  // 与网格相交的区域
+}
+
+// 初始化
+func (g *Grid) init() {
+	g.entitys = make(map[int]*aoiNode)
+	g.watchers = make(map[int]*aoiNode)
+	g.regions = make(map[int]*Region)
 }
 
 // 管理
 type AOIManager struct {
-	minX, maxX, minY, maxY float32  // 地图范围
-	gsize                  float32  // 网格大小
-	grids                  [][]Grid // 网格
-	xNum, yNum             int      // 网格数量
+	minX, maxX, minY, maxY float32                // 地图范围
+	gsize                  float32                // 网格大小
+	grids                  [][]Grid               // 网格
+	xNum, yNum             int                    // 网格数量
+	radius                 float32                // 视野半径，为0时按九宫格判断可见性
+	hysteresis             float32                // 离开视野的缓冲距离
+	canSeeFunc             CanSeeFunc             // 可见性判断
+	vertical               float32                // 垂直可见范围，为0时不判断高度
+	outOfBounds            func(Entity, Position) // 越界回调，为空时把越界坐标限制到边界网格
+	capacity               int                    // 视野内实体数量上限，为0时不限制
+	priority               PriorityFunc           // 视野内实体的优先级
+	nodes                  map[int]*aoiNode       // 地图中的实体
+	blockMap               *BlockMap              // 阻挡地图，为空时不检查视线
+	regions                map[int]*Region        // 区域
+	tierBounds             []int                  // 各层级的最大网格距离，为空时不分层
+	batch                  *aoiBatch              // 批量事件，为空时直接回调
+	reason                 Reason                 // 当前操作的原因
+	counters               aoiCounters            // 回调次数统计
+}
+
+// 选项
+type Option func(*AOIManager)
+
+// 按视野半径判断可见性，网格只用于筛选候选实体
+func WithRadius(radius float32) Option {
+	return func(m *AOIManager) {
+		m.radius = radius
+	}
+}
+
+// 设置离开视野的缓冲距离：进入视野按视野范围判断，离开视野要超出视野范围加缓冲距离，
+// 避免实体在边界附近来回移动时反复进出视野
+func WithHysteresis(band float32) Option {
+	return func(m *AOIManager) {
+		m.hysteresis = band
+	}
+}
+
+// 设置垂直可见范围，高度差超出范围的实体互相不可见，用于多层地图和飞行坐骑
+func WithVerticalRange(h float32) Option {
+	return func(m *AOIManager) {
+		m.vertical = h
+	}
+}
+
+// 开启越界检查：进入或移动到地图范围外时，不再限制到边界网格，而是拒绝本次操作并回调f
+func WithBoundsCheck(f func(entity Entity, pos Position)) Option {
+	return func(m *AOIManager) {
+		m.outOfBounds = f
+	}
+}
+
+// 可见性判断，在视野范围内时进一步判断观察者能否看到实体
+type CanSeeFunc func(watcher, target Entity) bool
+
+// 设置可见性判断，用于隐身、阵营、GM隐身等，判断条件变化后需调用Refresh
+func WithCanSee(f CanSeeFunc) Option {
+	return func(m *AOIManager) {
+		m.canSeeFunc = f
+	}
 }
 
 // 创建管理
-func NewAOIManager(minX, maxX, minY, maxY float32, gsize float32) *AOIManager {
+func NewAOIManager(minX, maxX, minY, maxY float32, gsize float32, opts ...Option) *AOIManager {
 	xNum := int((maxX-minX)/gsize) + 1
 	yNum := int((maxY-minY)/gsize) + 1
 
 	mgr := &AOIManager{
-		minX:  minX,
-		maxX:  maxX,
-		minY:  minY,
-		maxY:  maxY,
-		gsize: gsize,
-		xNum:  xNum,
-		yNum:  yNum,
+		minX:    minX,
+		maxX:    maxX,
+		minY:    minY,
+		maxY:    maxY,
+		gsize:   gsize,
+		xNum:    xNum,
+		yNum:    yNum,
+		nodes:   make(map[int]*aoiNode),
+		regions: make(map[int]*Region),
+	}
+	for _, opt := range opts {
+		opt(mgr)
 	}
 
 	mgr.grids = make([][]Grid, xNum)
@@ -98,76 +398,303 @@ func NewAOIManager(minX, maxX, minY, maxY float32, gsize float32) *AOIManager {
 
 // 进入地图
 func (m *AOIManager) Enter(entity Entity, pos Position) {
-	// 添加实体
-	entity.SetPos(pos)
-	grid := m.posToGrid(pos)
-	grid.addEntity(entity)
-	// 添加观察者
-	m.visitWatchGrids(pos, func(g *Grid) {
-		g.addWatcher(entity)
-	})
+	m.EnterMode(entity, pos, ModeBoth)
+}
+
+// 按指定模式进入地图，离开和移动时沿用进入时的模式
+func (m *AOIManager) EnterMode(entity Entity, pos Position, mode Mode) {
+	if !m.checkBounds(entity, pos) {
+		return
+	}
+	n := newAOINode(entity)
+	n.mode = mode
+	m.reason = ReasonEnter
+	m.add(n, pos)
 }
 
 // 离开地图
 func (m *AOIManager) Leave(entity Entity) {
-	// 移除实体
-	grid := m.posToGrid(entity.GetPos())
-	grid.removeEntity(entity)
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	m.reason = ReasonLeave
+	m.remove(n)
+}
+
+// 传送，视为以ReasonTeleport原因离开再进入，传送前后都能看到实体的观察者也会收到离开和进入回调
+func (m *AOIManager) Teleport(entity Entity, pos Position) {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	if !m.checkBounds(entity, pos) {
+		return
+	}
+	m.reason = ReasonTeleport
+	m.detach(n)
+	m.add(n, pos)
+}
+
+// 添加实体
+func (m *AOIManager) add(n *aoiNode, pos Position) {
+	n.pos = pos
+	n.entity.SetPos(pos)
+	m.nodes[n.entity.ID()] = n
+	if n.mode&ModeWatched != 0 {
+		m.posToGrid(pos).entitys[n.entity.ID()] = n
+	}
+	m.update(n, pos, pos)
+}
+
+// 移除实体
+func (m *AOIManager) remove(n *aoiNode) {
+	if n.mode&ModeWatched != 0 {
+		m.exitRegions(n)
+	}
+	m.detach(n)
+}
+
+// 从网格和视野关系中摘除实体，保留所在的区域，传送时由add按新坐标更新区域
+func (m *AOIManager) detach(n *aoiNode) {
+	delete(m.nodes, n.entity.ID())
+	if n.mode&ModeWatched != 0 {
+		delete(m.posToGrid(n.pos).entitys, n.entity.ID())
+	}
 	// 移除观察者
-	m.visitWatchGrids(entity.GetPos(), func(g *Grid) {
-		g.removeWatcher(entity)
-	})
+	m.setWatchGrids(n, 0, -1, 0, -1)
+	n.clear(m)
 }
 
 // 移动
 func (m *AOIManager) Move(entity Entity, toPos Position) {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	if !m.checkBounds(entity, toPos) {
+		return
+	}
 	// 更新位置
-	fromPos := entity.GetPos()
-	entity.SetPos(toPos)
+	m.reason = ReasonMove
+	fromPos := n.pos
+	n.pos = toPos
+	n.entity.SetPos(toPos)
 	fromGrid := m.posToGrid(fromPos)
 	toGrid := m.posToGrid(toPos)
-	if fromGrid == toGrid {
+	if fromGrid != toGrid && n.mode&ModeWatched != 0 {
+		// 跨越网格
+		delete(fromGrid.entitys, entity.ID())
+		toGrid.entitys[entity.ID()] = n
+	}
+	m.update(n, fromPos, toPos)
+}
+
+// 判断坐标是否在地图范围内
+func (m *AOIManager) InBounds(pos Position) bool {
+	return pos.x >= m.minX && pos.x <= m.maxX && pos.y >= m.minY && pos.y <= m.maxY
+}
+
+// 越界检查，越界时回调并返回false
+func (m *AOIManager) checkBounds(entity Entity, pos Position) bool {
+	if m.outOfBounds == nil || m.InBounds(pos) {
+		return true
+	}
+	m.outOfBounds(entity, pos)
+	return false
+}
+
+// 重新判断实体与周围实体的可见性，用于可见性判断的条件（如隐身、阵营、视野范围）变化后
+func (m *AOIManager) Refresh(entity Entity) {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	m.reason = ReasonRefresh
+	m.update(n, n.pos, n.pos)
+}
+
+// 更新实体的观察者和视野
+func (m *AOIManager) update(n *aoiNode, fromPos, toPos Position) {
+	// 更新能看到实体的观察者和所在的区域
+	if n.mode&ModeWatched != 0 {
+		m.updateWatchers(n, fromPos, toPos)
+		if !n.ghost {
+			m.updateRegions(n)
+		}
+	}
+	// 更新实体的视野
+	if n.mode&ModeWatcher != 0 {
+		n.viewRange = m.getViewRange(n.entity)
+		xmin, xmax, ymin, ymax := m.getWatchGrids(toPos, n.viewRange)
+		m.setWatchGrids(n, xmin, xmax, ymin, ymax)
+		m.updateWatching(n)
+	}
+	// 更新层级
+	if m.tierBounds != nil && fromPos != toPos {
+		m.updateTiers(n)
+	}
+}
+
+// 判断观察者能否看到实体
+func (m *AOIManager) canSee(w, n *aoiNode) bool {
+	// 已在视野内的实体，按视野范围加缓冲距离判断
+	r := w.viewRange
+	_, visible := w.watching[n.entity.ID()]
+	if visible {
+		r += m.hysteresis
+	}
+	pos := n.pos
+	if m.vertical > 0 {
+		dz := pos.z - w.pos.z
+		h := m.vertical
+		if visible {
+			h += m.hysteresis
+		}
+		if dz < -h || dz > h {
+			return false
+		}
+	}
+	if m.radius > 0 {
+		if distSq(w.pos, pos) > r*r {
+			return false
+		}
+	} else {
+		xmin, xmax, ymin, ymax := w.xmin, w.xmax, w.ymin, w.ymax
+		if visible && m.hysteresis > 0 {
+			xmin, xmax, ymin, ymax = m.getWatchGrids(w.pos, r)
+		}
+		x, y := m.transXY(pos.x, pos.y)
+		if x < xmin || x > xmax || y < ymin || y > ymax {
+			return false
+		}
+	}
+	if m.canSeeFunc != nil && !m.canSeeFunc(w.entity, n.entity) {
+		return false
+	}
+	return m.blockMap == nil || m.blockMap.LineOfSight(w.pos, pos)
+}
+
+// 通知实体进入视野
+func (m *AOIManager) notifyEnter(w, n *aoiNode) {
+	m.setTier(w, n)
+	if m.batch != nil {
+		m.batch.add(w, n, batchEnter, m.reason)
+		return
+	}
+	m.callEnter(w.entity, n.entity, m.reason)
+}
+
+// 通知实体离开视野
+func (m *AOIManager) notifyLeave(w, n *aoiNode) {
+	if m.batch != nil {
+		// 先记录事件，保留本帧开始时的层级
+		m.batch.add(w, n, batchLeave, m.reason)
+		delete(w.tiers, n.entity.ID())
+		return
+	}
+	delete(w.tiers, n.entity.ID())
+	m.callLeave(w.entity, n.entity, m.reason)
+}
+
+// 通知实体在视野内移动
+func (m *AOIManager) notifyMove(w, n *aoiNode, from, to Position) {
+	if m.batch != nil {
+		m.batch.addMove(w, n, from, to)
+		return
+	}
+	m.callMove(w.entity, n.entity, from, to)
+}
+
+// 更新观察者的视野
+func (m *AOIManager) updateWatching(n *aoiNode) {
+	if m.capacity > 0 {
+		m.selectWatching(n)
 		return
 	}
+	n.updateWatching(m, func(f func(*aoiNode)) {
+		m.visitCandidates(n, f)
+	})
+}
 
-	// 跨越网格
-	fromGrid.removeEntity(entity)
-	toGrid.addEntity(entity)
+// 更新能看到实体的观察者
+func (m *AOIManager) updateWatchers(n *aoiNode, fromPos, toPos Position) {
+	if m.capacity > 0 {
+		m.updateLimitedWatchers(n, fromPos, toPos)
+		return
+	}
+	n.updateWatchers(m, func(f func(*aoiNode)) {
+		for _, w := range m.posToGrid(toPos).watchers {
+			f(w)
+		}
+	}, fromPos, toPos)
+}
 
-	// 更新观察者
-	fxmin, fxmax, fymin, fymax := m.getWatchGrids(fromPos)
-	txmin, txmax, tymin, tymax := m.getWatchGrids(toPos)
+// 遍历观察网格范围内的实体
+func (m *AOIManager) visitCandidates(n *aoiNode, f func(*aoiNode)) {
+	m.visitGrids(n.xmin, n.xmax, n.ymin, n.ymax, func(g *Grid) {
+		for _, other := range g.entitys {
+			f(other)
+		}
+	})
+}
 
-	for x := fxmin; x <= fxmax; x++ {
-		for y := fymin; y <= fymax; y++ {
-			if x >= txmin && x <= txmax && y >= tymin && y <= tymax {
+// 获取视野内的实体
+func (m *AOIManager) Watching(entity Entity) []Entity {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return nil
+	}
+	return n.watchingList()
+}
+
+// 获取能看到实体的观察者
+func (m *AOIManager) Watchers(entity Entity) []Entity {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return nil
+	}
+	return n.watcherList()
+}
+
+// 更新观察的网格范围
+func (m *AOIManager) setWatchGrids(n *aoiNode, xmin, xmax, ymin, ymax int) {
+	for x := n.xmin; x <= n.xmax; x++ {
+		for y := n.ymin; y <= n.ymax; y++ {
+			if x >= xmin && x <= xmax && y >= ymin && y <= ymax {
 				continue
 			}
-			grid := &m.grids[x][y]
-			grid.removeWatcher(entity)
+			delete(m.grids[x][y].watchers, n.entity.ID())
 		}
 	}
-	for x := txmin; x <= txmax; x++ {
-		for y := tymin; y <= tymax; y++ {
-			if x >= fxmin && x <= fxmax && y >= fymin && y <= fymax {
+	for x := xmin; x <= xmax; x++ {
+		for y := ymin; y <= ymax; y++ {
+			if x >= n.xmin && x <= n.xmax && y >= n.ymin && y <= n.ymax {
 				continue
 			}
-			grid := &m.grids[x][y]
-			grid.addWatcher(entity)
+			m.grids[x][y].watchers[n.entity.ID()] = n
 		}
 	}
+	n.xmin, n.xmax, n.ymin, n.ymax = xmin, xmax, ymin, ymax
+}
+
+// 获取实体的视野范围，默认为视野半径或网格大小
+func (m *AOIManager) getViewRange(entity Entity) float32 {
+	if m.radius > 0 {
+		return viewRangeOf(entity, m.radius)
+	}
+	return viewRangeOf(entity, m.gsize)
 }
 
-// 获取九宫格范围
-func (m *AOIManager) getWatchGrids(pos Position) (int, int, int, int) {
-	xmin, ymin := m.transXY(pos.x-m.gsize, pos.y-m.gsize)
-	xmax, ymax := m.transXY(pos.x+m.gsize, pos.y+m.gsize)
+// 获取观察的网格范围，默认为九宫格
+func (m *AOIManager) getWatchGrids(pos Position, r float32) (int, int, int, int) {
+	xmin, ymin := m.transXY(pos.x-r, pos.y-r)
+	xmax, ymax := m.transXY(pos.x+r, pos.y+r)
 	return xmin, xmax, ymin, ymax
 }
 
-// 遍历九宫格
-func (m *AOIManager) visitWatchGrids(pos Position, f func(*Grid)) {
-	xmin, xmax, ymin, ymax := m.getWatchGrids(pos)
+// 遍历网格范围
+func (m *AOIManager) visitGrids(xmin, xmax, ymin, ymax int, f func(*Grid)) {
 	for x := xmin; x <= xmax; x++ {
 		for y := ymin; y <= ymax; y++ {
 			grid := &m.grids[x][y]
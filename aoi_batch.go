@@ -0,0 +1,174 @@
+package aoi
+
+/*
+批量事件：开启后，视野事件不再立即回调，而是按观察者累积，每帧调用Flush时统一派发。
+同一帧内对同一实体的多次事件会合并：进入后又离开的相互抵消，离开后又进入的视为移动；
+传送后仍在视野内的不合并，按ReasonTeleport原因派发离开和进入。
+开启层级时，一直在视野内的实体层级与本帧开始时不同的，在进入、离开和移动之后回调OnTierChange。
+*/
+
+// 可选接口，批量接收一帧内的视野事件
+type BatchWatcher interface {
+	OnAOIBatch(enters, leaves, moves []Entity)
+}
+
+// 事件类型
+const (
+	batchEnter = iota
+	batchLeave
+	batchMove
+	batchTier
+)
+
+// 合并后的事件
+type batchEvent struct {
+	entity   Entity
+	was      bool     // 本帧开始时是否在视野内
+	now      bool     // 当前是否在视野内
+	moved    bool     // 本帧内是否移动
+	teleport bool     // 本帧内是否传送后仍在视野内
+	from, to Position // 移动的起点和终点
+	reason   Reason   // 最后一次进入或离开的原因
+	tier     Tier     // 本帧开始时的层级，派发时为当前层级
+}
+
+// 观察者的事件
+type watcherBatch struct {
+	entity Entity
+	events map[int]*batchEvent
+}
+
+// 批量事件
+type aoiBatch struct {
+	watchers map[int]*watcherBatch
+}
+
+// 开启批量事件，需要每帧调用Flush派发
+func WithBatch() Option {
+	return func(m *AOIManager) {
+		m.batch = &aoiBatch{watchers: make(map[int]*watcherBatch)}
+	}
+}
+
+// 获取观察者对实体的事件
+func (b *aoiBatch) get(w, n *aoiNode, typ int) *batchEvent {
+	wb, ok := b.watchers[w.entity.ID()]
+	if !ok {
+		wb = &watcherBatch{entity: w.entity, events: make(map[int]*batchEvent)}
+		b.watchers[w.entity.ID()] = wb
+	}
+	ev, ok := wb.events[n.entity.ID()]
+	if !ok {
+		// 第一个事件不是进入，说明本帧开始时在视野内
+		ev = &batchEvent{entity: n.entity, was: typ != batchEnter}
+		if ev.was {
+			ev.tier = w.tiers[n.entity.ID()]
+		}
+		wb.events[n.entity.ID()] = ev
+	}
+	return ev
+}
+
+// 添加进入或离开事件
+func (b *aoiBatch) add(w, n *aoiNode, typ int, reason Reason) {
+	ev := b.get(w, n, typ)
+	ev.now = typ == batchEnter
+	ev.reason = reason
+	if ev.now && ev.was && reason == ReasonTeleport {
+		ev.teleport = true
+	} else if ev.now && ev.was {
+		// 离开后又进入，视为移动到当前位置
+		pos := n.pos
+		if !ev.moved {
+			ev.from = pos
+		}
+		ev.moved = true
+		ev.to = pos
+	}
+}
+
+// 添加移动事件
+func (b *aoiBatch) addMove(w, n *aoiNode, from, to Position) {
+	ev := b.get(w, n, batchMove)
+	if !ev.moved {
+		ev.from = from
+	}
+	ev.now = true
+	ev.moved = true
+	ev.to = to
+}
+
+// 添加层级变化事件，需在更新层级之前调用以记录本帧开始时的层级
+func (b *aoiBatch) addTier(w, n *aoiNode) {
+	ev := b.get(w, n, batchTier)
+	ev.now = true
+}
+
+// 派发本帧累积的事件
+func (m *AOIManager) Flush() {
+	if m.batch == nil {
+		return
+	}
+	watchers := m.batch.watchers
+	m.batch.watchers = make(map[int]*watcherBatch)
+	for _, wb := range watchers {
+		wb.flush(m)
+	}
+}
+
+// 派发观察者的事件，未实现BatchWatcher时逐个回调
+func (wb *watcherBatch) flush(m *AOIManager) {
+	var enters, leaves, moves, tiers []*batchEvent
+	for _, ev := range wb.events {
+		if ev.was && ev.now && !ev.teleport && m.tierBounds != nil {
+			if tier, ok := m.Tier(wb.entity, ev.entity); ok && tier != ev.tier {
+				ev.tier = tier
+				tiers = append(tiers, ev)
+			}
+		}
+		switch {
+		case !ev.was && ev.now:
+			enters = append(enters, ev)
+		case ev.was && !ev.now:
+			leaves = append(leaves, ev)
+		case ev.was && ev.now && ev.teleport:
+			leaves = append(leaves, ev)
+			enters = append(enters, ev)
+		case ev.was && ev.now && ev.moved:
+			moves = append(moves, ev)
+		}
+	}
+
+	if len(enters) > 0 || len(leaves) > 0 || len(moves) > 0 {
+		if bw, ok := wb.entity.(BatchWatcher); ok {
+			m.counters.enters += len(enters)
+			m.counters.leaves += len(leaves)
+			m.counters.moves += len(moves)
+			bw.OnAOIBatch(batchEntitys(enters), batchEntitys(leaves), batchEntitys(moves))
+		} else {
+			for _, ev := range leaves {
+				m.callLeave(wb.entity, ev.entity, ev.reason)
+			}
+			for _, ev := range enters {
+				m.callEnter(wb.entity, ev.entity, ev.reason)
+			}
+			for _, ev := range moves {
+				m.callMove(wb.entity, ev.entity, ev.from, ev.to)
+			}
+		}
+	}
+	if tw, ok := wb.entity.(TierWatcher); ok {
+		for _, ev := range tiers {
+			tw.OnTierChange(ev.entity, ev.tier)
+		}
+	}
+}
+
+// 事件中的实体列表
+func batchEntitys(events []*batchEvent) []Entity {
+	list := make([]Entity, len(events))
+	for i, ev := range events {
+		list[i] = ev.entity
+	}
+	return list
+}
@@ -0,0 +1,118 @@
+package aoi
+
+import (
+	"math"
+)
+
+/*
+阻挡地图：按格子记录墙体等阻挡，用DDA算法沿视线逐格检查，视线经过阻挡格子时互相不可见。
+开启后，进入视野前先检查视线，任一端移动时重新检查；阻挡变化（如开门）后需对附近实体调用Refresh。
+*/
+
+// 阻挡地图
+type BlockMap struct {
+	minX, minY    float32  // 地图起点
+	cellSize      float32  // 格子大小
+	width, height int      // 格子数量
+	bits          []uint64 // 阻挡位图
+}
+
+// 创建阻挡地图
+func NewBlockMap(minX, maxX, minY, maxY float32, cellSize float32) *BlockMap {
+	width := int((maxX-minX)/cellSize) + 1
+	height := int((maxY-minY)/cellSize) + 1
+	return &BlockMap{
+		minX:     minX,
+		minY:     minY,
+		cellSize: cellSize,
+		width:    width,
+		height:   height,
+		bits:     make([]uint64, (width*height+63)/64),
+	}
+}
+
+// 开启视线检查
+func WithBlockMap(b *BlockMap) Option {
+	return func(m *AOIManager) {
+		m.blockMap = b
+	}
+}
+
+// 获取坐标所在的格子
+func (b *BlockMap) Cell(pos Position) (int, int) {
+	return int(math.Floor(float64((pos.x - b.minX) / b.cellSize))),
+		int(math.Floor(float64((pos.y - b.minY) / b.cellSize)))
+}
+
+// 设置格子是否阻挡
+func (b *BlockMap) SetBlocked(cx, cy int, blocked bool) {
+	if cx < 0 || cx >= b.width || cy < 0 || cy >= b.height {
+		return
+	}
+	i := cy*b.width + cx
+	if blocked {
+		b.bits[i/64] |= 1 << (i % 64)
+	} else {
+		b.bits[i/64] &^= 1 << (i % 64)
+	}
+}
+
+// 判断格子是否阻挡，地图外不阻挡
+func (b *BlockMap) Blocked(cx, cy int) bool {
+	if cx < 0 || cx >= b.width || cy < 0 || cy >= b.height {
+		return false
+	}
+	i := cy*b.width + cx
+	return b.bits[i/64]&(1<<(i%64)) != 0
+}
+
+// 判断两点之间的视线是否畅通，两端所在的格子不检查
+func (b *BlockMap) LineOfSight(from, to Position) bool {
+	size := float64(b.cellSize)
+	x0, y0 := float64(from.x-b.minX)/size, float64(from.y-b.minY)/size
+	x1, y1 := float64(to.x-b.minX)/size, float64(to.y-b.minY)/size
+	cx, cy := int(math.Floor(x0)), int(math.Floor(y0))
+	ex, ey := int(math.Floor(x1)), int(math.Floor(y1))
+
+	// 沿x、y方向到达下一条格子边界所需的参数t
+	stepX, tMaxX, tDeltaX := ddaAxis(x0, x1)
+	stepY, tMaxY, tDeltaY := ddaAxis(y0, y1)
+
+	n := absInt(ex-cx) + absInt(ey-cy)
+	for i := 0; i < n; i++ {
+		if tMaxX < tMaxY {
+			tMaxX += tDeltaX
+			cx += stepX
+		} else {
+			tMaxY += tDeltaY
+			cy += stepY
+		}
+		if cx == ex && cy == ey {
+			break
+		}
+		if b.Blocked(cx, cy) {
+			return false
+		}
+	}
+	return true
+}
+
+// DDA单个坐标轴的步进方向、到达第一条边界的参数t、跨过一个格子的参数t
+func ddaAxis(v0, v1 float64) (int, float64, float64) {
+	d := v1 - v0
+	switch {
+	case d > 0:
+		return 1, (math.Floor(v0) + 1 - v0) / d, 1 / d
+	case d < 0:
+		return -1, (v0 - math.Floor(v0)) / -d, 1 / -d
+	default:
+		return 0, math.Inf(1), math.Inf(1)
+	}
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
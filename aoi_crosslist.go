@@ -0,0 +1,192 @@
+package aoi
+
+/*
+十字链表AOI：所有实体按x坐标和y坐标分别排序，串成两条双向链表。
+进入时从链表头找到插入位置，移动时从当前位置向前后调整，查询时沿两条链表向两侧遍历，取交集即为范围内的实体。
+内存只与实体数量有关，适合地图很大、实体稀疏的场景；实体密集时链表遍历较慢，应使用网格。
+*/
+
+// 十字链表节点
+type crossNode struct {
+	*aoiNode
+	x, y         float32    // 排序坐标
+	xPrev, xNext *crossNode // x链表
+	yPrev, yNext *crossNode // y链表
+}
+
+// 十字链表
+type CrossListAOI struct {
+	directNotifier
+	radius float32            // 默认视野半径
+	xHead  crossNode          // x链表头
+	yHead  crossNode          // y链表头
+	nodes  map[int]*crossNode // 地图中的实体
+	ranges viewRanges         // 视野范围统计
+}
+
+// 创建十字链表
+func NewCrossListAOI(radius float32) *CrossListAOI {
+	return &CrossListAOI{
+		radius: radius,
+		nodes:  make(map[int]*crossNode),
+	}
+}
+
+// 进入地图
+func (c *CrossListAOI) Enter(entity Entity, pos Position) {
+	entity.SetPos(pos)
+	n := &crossNode{aoiNode: newAOINode(entity), x: pos.x, y: pos.y}
+	c.nodes[entity.ID()] = n
+	n.viewRange = viewRangeOf(entity, c.radius)
+	c.ranges.add(n.viewRange)
+	// 插入链表
+	c.insertX(&c.xHead, n)
+	c.insertY(&c.yHead, n)
+	// 更新视野
+	c.updateWatchers(n, pos, pos)
+	c.updateWatching(n)
+}
+
+// 离开地图
+func (c *CrossListAOI) Leave(entity Entity) {
+	n, ok := c.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	delete(c.nodes, entity.ID())
+	c.ranges.remove(n.viewRange)
+	// 移出链表
+	c.removeX(n)
+	c.removeY(n)
+	n.clear(c)
+}
+
+// 移动
+func (c *CrossListAOI) Move(entity Entity, toPos Position) {
+	n, ok := c.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	fromPos := entity.GetPos()
+	entity.SetPos(toPos)
+	c.ranges.remove(n.viewRange)
+	n.viewRange = viewRangeOf(entity, c.radius)
+	c.ranges.add(n.viewRange)
+	// 调整链表位置
+	n.x, n.y = toPos.x, toPos.y
+	prev := n.xPrev
+	c.removeX(n)
+	for prev != &c.xHead && prev.x > n.x {
+		prev = prev.xPrev
+	}
+	c.insertX(prev, n)
+	prev = n.yPrev
+	c.removeY(n)
+	for prev != &c.yHead && prev.y > n.y {
+		prev = prev.yPrev
+	}
+	c.insertY(prev, n)
+	// 更新视野
+	c.updateWatchers(n, fromPos, toPos)
+	c.updateWatching(n)
+}
+
+// 获取视野内的实体
+func (c *CrossListAOI) Watching(entity Entity) []Entity {
+	n, ok := c.nodes[entity.ID()]
+	if !ok {
+		return nil
+	}
+	return n.watchingList()
+}
+
+// 获取能看到实体的观察者
+func (c *CrossListAOI) Watchers(entity Entity) []Entity {
+	n, ok := c.nodes[entity.ID()]
+	if !ok {
+		return nil
+	}
+	return n.watcherList()
+}
+
+// 判断观察者能否看到实体
+func (c *CrossListAOI) canSee(w, n *aoiNode) bool {
+	return distSq(w.entity.GetPos(), n.entity.GetPos()) <= w.viewRange*w.viewRange
+}
+
+// 更新观察者的视野
+func (c *CrossListAOI) updateWatching(n *crossNode) {
+	n.updateWatching(c, func(f func(*aoiNode)) {
+		c.visitRange(n, n.viewRange, f)
+	})
+}
+
+// 更新能看到实体的观察者
+func (c *CrossListAOI) updateWatchers(n *crossNode, fromPos, toPos Position) {
+	n.updateWatchers(c, func(f func(*aoiNode)) {
+		c.visitRange(n, c.ranges.max, f)
+	}, fromPos, toPos)
+}
+
+// 遍历与节点x、y坐标之差都不超过r的实体：先沿x链表标记，再沿y链表取交集
+func (c *CrossListAOI) visitRange(n *crossNode, r float32, f func(*aoiNode)) {
+	xset := make(map[*crossNode]struct{})
+	for p := n.xPrev; p != &c.xHead && n.x-p.x <= r; p = p.xPrev {
+		xset[p] = struct{}{}
+	}
+	for p := n.xNext; p != nil && p.x-n.x <= r; p = p.xNext {
+		xset[p] = struct{}{}
+	}
+	for p := n.yPrev; p != &c.yHead && n.y-p.y <= r; p = p.yPrev {
+		if _, ok := xset[p]; ok {
+			f(p.aoiNode)
+		}
+	}
+	for p := n.yNext; p != nil && p.y-n.y <= r; p = p.yNext {
+		if _, ok := xset[p]; ok {
+			f(p.aoiNode)
+		}
+	}
+}
+
+// 从prev开始向后查找位置，插入x链表
+func (c *CrossListAOI) insertX(prev, n *crossNode) {
+	for prev.xNext != nil && prev.xNext.x < n.x {
+		prev = prev.xNext
+	}
+	n.xPrev, n.xNext = prev, prev.xNext
+	if prev.xNext != nil {
+		prev.xNext.xPrev = n
+	}
+	prev.xNext = n
+}
+
+// 从prev开始向后查找位置，插入y链表
+func (c *CrossListAOI) insertY(prev, n *crossNode) {
+	for prev.yNext != nil && prev.yNext.y < n.y {
+		prev = prev.yNext
+	}
+	n.yPrev, n.yNext = prev, prev.yNext
+	if prev.yNext != nil {
+		prev.yNext.yPrev = n
+	}
+	prev.yNext = n
+}
+
+// 移出x链表
+func (c *CrossListAOI) removeX(n *crossNode) {
+	n.xPrev.xNext = n.xNext
+	if n.xNext != nil {
+		n.xNext.xPrev = n.xPrev
+	}
+	n.xPrev, n.xNext = nil, nil
+}
+
+// 移出y链表
+func (c *CrossListAOI) removeY(n *crossNode) {
+	n.yPrev.yNext = n.yNext
+	if n.yNext != nil {
+		n.yNext.yPrev = n.yPrev
+	}
+	n.yPrev, n.yNext = nil, nil
+}
@@ -0,0 +1,399 @@
+package aoi
+
+import (
+	"container/heap"
+	"math"
+)
+
+/*
+寻路网格：与AOI地图使用相同的范围，按格子记录能否行走和通行代价，在此之上用A*寻路。
+八方向移动，斜向移动时两侧的格子都要能行走，不能穿过墙角。
+所有格子代价相同时可以用跳点搜索（JPS）加速，找到的路径长度与A*相同。
+路径为格子中心组成的路点，最后一个路点为终点；平滑时只在直线的代价不高于原路径时跳过路点，平滑后可直接用于Move。
+*/
+
+// 斜向移动的代价
+const navDiagonal = math.Sqrt2
+
+// 寻路网格
+type NavGrid struct {
+	minX, minY    float32   // 地图起点
+	cellSize      float32   // 格子大小
+	width, height int       // 格子数量
+	costs         []float32 // 通行代价，0为不能行走
+	weighted      int       // 代价不为1的格子数量，为0时可以用跳点搜索
+}
+
+// 创建寻路网格，所有格子都能行走，代价为1
+func NewNavGrid(minX, maxX, minY, maxY float32, cellSize float32) *NavGrid {
+	width := int((maxX-minX)/cellSize) + 1
+	height := int((maxY-minY)/cellSize) + 1
+	g := &NavGrid{
+		minX:     minX,
+		minY:     minY,
+		cellSize: cellSize,
+		width:    width,
+		height:   height,
+		costs:    make([]float32, width*height),
+	}
+	for i := range g.costs {
+		g.costs[i] = 1
+	}
+	return g
+}
+
+// 按地图范围创建寻路网格，cellSize为0时格子与AOI网格相同
+func (m *AOIManager) NewNavGrid(cellSize float32) *NavGrid {
+	if cellSize <= 0 {
+		cellSize = m.gsize
+	}
+	return NewNavGrid(m.minX, m.maxX, m.minY, m.maxY, cellSize)
+}
+
+// 获取坐标所在的格子，地图外的坐标限制到边界格子
+func (g *NavGrid) Cell(pos Position) (int, int) {
+	cx := int(math.Floor(float64((pos.x - g.minX) / g.cellSize)))
+	cy := int(math.Floor(float64((pos.y - g.minY) / g.cellSize)))
+	return min(max(cx, 0), g.width-1), min(max(cy, 0), g.height-1)
+}
+
+// 格子中心的坐标
+func (g *NavGrid) Center(cx, cy int) Position {
+	return Position{x: g.minX + (float32(cx)+0.5)*g.cellSize, y: g.minY + (float32(cy)+0.5)*g.cellSize}
+}
+
+// 设置格子能否行走，能行走时代价恢复为1
+func (g *NavGrid) SetWalkable(cx, cy int, walkable bool) {
+	if walkable {
+		g.SetCost(cx, cy, 1)
+	} else {
+		g.SetCost(cx, cy, 0)
+	}
+}
+
+// 设置格子的通行代价，0为不能行走，其余小于1的按1处理
+func (g *NavGrid) SetCost(cx, cy int, cost float32) {
+	if cx < 0 || cx >= g.width || cy < 0 || cy >= g.height {
+		return
+	}
+	if cost > 0 && cost < 1 {
+		cost = 1
+	}
+	i := cy*g.width + cx
+	if old := g.costs[i]; old != 0 && old != 1 {
+		g.weighted--
+	}
+	if cost != 0 && cost != 1 {
+		g.weighted++
+	}
+	g.costs[i] = cost
+}
+
+// 判断格子能否行走，地图外不能行走
+func (g *NavGrid) Walkable(cx, cy int) bool {
+	return g.cost(cx, cy) > 0
+}
+
+// 格子的通行代价，地图外为0
+func (g *NavGrid) cost(cx, cy int) float32 {
+	if cx < 0 || cx >= g.width || cy < 0 || cy >= g.height {
+		return 0
+	}
+	return g.costs[cy*g.width+cx]
+}
+
+// 判断能否从格子向(dx, dy)方向移动一格，斜向移动时两侧的格子都要能行走
+func (g *NavGrid) canStep(cx, cy, dx, dy int) bool {
+	if !g.Walkable(cx+dx, cy+dy) {
+		return false
+	}
+	return dx == 0 || dy == 0 || g.Walkable(cx+dx, cy) && g.Walkable(cx, cy+dy)
+}
+
+// 八方向距离，作为A*的估价
+func octile(dx, dy int) float32 {
+	dx, dy = absInt(dx), absInt(dy)
+	return float32(max(dx, dy)-min(dx, dy)) + navDiagonal*float32(min(dx, dy))
+}
+
+// 开放列表中的格子
+type navItem struct {
+	cell int
+	f    float32
+}
+
+// 开放列表，按f从小到大
+type navHeap []navItem
+
+func (h navHeap) Len() int           { return len(h) }
+func (h navHeap) Less(i, j int) bool { return h[i].f < h[j].f }
+func (h navHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *navHeap) Push(x any)        { *h = append(*h, x.(navItem)) }
+func (h *navHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// A*寻路，返回从起点到终点的路点，不包括起点；起点或终点不能行走、找不到路径时返回空
+func (g *NavGrid) FindPath(from, to Position) []Position {
+	return g.search(from, to, func(cx, cy, px, py int, f func(nx, ny int)) {
+		g.allNeighbors(cx, cy, f)
+	})
+}
+
+// 跳点搜索，只在所有格子代价相同时使用，否则按A*寻路
+func (g *NavGrid) FindPathJPS(from, to Position) []Position {
+	if g.weighted > 0 {
+		return g.FindPath(from, to)
+	}
+	ex, ey := g.Cell(to)
+	return g.search(from, to, func(cx, cy, px, py int, f func(nx, ny int)) {
+		g.prunedNeighbors(cx, cy, px, py, func(nx, ny int) {
+			if jx, jy, ok := g.jump(nx, ny, cx, cy, ex, ey); ok {
+				f(jx, jy)
+			}
+		})
+	})
+}
+
+// 搜索路径，successors遍历格子的后继，(px, py)为父节点，起点的父节点为自己
+func (g *NavGrid) search(from, to Position, successors func(cx, cy, px, py int, f func(nx, ny int))) []Position {
+	sx, sy := g.Cell(from)
+	ex, ey := g.Cell(to)
+	if !g.Walkable(sx, sy) || !g.Walkable(ex, ey) {
+		return nil
+	}
+	if sx == ex && sy == ey {
+		return []Position{to}
+	}
+	start, goal := sy*g.width+sx, ey*g.width+ex
+	gScore := map[int]float32{start: 0}
+	parent := make(map[int]int)
+	closed := make(map[int]bool)
+	open := &navHeap{{start, octile(ex-sx, ey-sy)}}
+	for open.Len() > 0 {
+		cur := heap.Pop(open).(navItem).cell
+		if closed[cur] {
+			continue
+		}
+		if cur == goal {
+			return g.buildPath(parent, start, goal, to)
+		}
+		closed[cur] = true
+		cx, cy := cur%g.width, cur/g.width
+		px, py := cx, cy
+		if p, ok := parent[cur]; ok {
+			px, py = p%g.width, p/g.width
+		}
+		successors(cx, cy, px, py, func(nx, ny int) {
+			next := ny*g.width + nx
+			if closed[next] {
+				return
+			}
+			score := gScore[cur] + g.segmentCost(cx, cy, nx, ny)
+			if old, ok := gScore[next]; ok && old <= score {
+				return
+			}
+			gScore[next] = score
+			parent[next] = cur
+			heap.Push(open, navItem{next, score + octile(ex-nx, ey-ny)})
+		})
+	}
+	return nil
+}
+
+// 直线或斜线经过的格子代价之和，不包括起点格子
+func (g *NavGrid) segmentCost(cx, cy, nx, ny int) float32 {
+	dx, dy := sign(nx-cx), sign(ny-cy)
+	step := float32(1)
+	if dx != 0 && dy != 0 {
+		step = navDiagonal
+	}
+	var cost float32
+	for cx != nx || cy != ny {
+		cx, cy = cx+dx, cy+dy
+		cost += step * g.cost(cx, cy)
+	}
+	return cost
+}
+
+// 由父节点回溯路径，中间路点为格子中心，最后一个为终点
+func (g *NavGrid) buildPath(parent map[int]int, start, goal int, to Position) []Position {
+	path := []Position{to}
+	for cur := parent[goal]; cur != start; cur = parent[cur] {
+		path = append(path, g.Center(cur%g.width, cur/g.width))
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// 遍历能到达的相邻格子
+func (g *NavGrid) allNeighbors(cx, cy int, f func(nx, ny int)) {
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			if (dx != 0 || dy != 0) && g.canStep(cx, cy, dx, dy) {
+				f(cx+dx, cy+dy)
+			}
+		}
+	}
+}
+
+// 按跳点搜索的规则遍历需要继续搜索的相邻格子，起点遍历所有相邻格子
+func (g *NavGrid) prunedNeighbors(cx, cy, px, py int, f func(nx, ny int)) {
+	dx, dy := sign(cx-px), sign(cy-py)
+	if dx == 0 && dy == 0 {
+		g.allNeighbors(cx, cy, f)
+		return
+	}
+	switch {
+	case dx != 0 && dy != 0:
+		for _, d := range [][2]int{{0, dy}, {dx, 0}, {dx, dy}} {
+			if g.canStep(cx, cy, d[0], d[1]) {
+				f(cx+d[0], cy+d[1])
+			}
+		}
+	case dx != 0:
+		for _, d := range [][2]int{{dx, 0}, {dx, 1}, {dx, -1}, {0, 1}, {0, -1}} {
+			if g.canStep(cx, cy, d[0], d[1]) {
+				f(cx+d[0], cy+d[1])
+			}
+		}
+	default:
+		for _, d := range [][2]int{{0, dy}, {1, dy}, {-1, dy}, {1, 0}, {-1, 0}} {
+			if g.canStep(cx, cy, d[0], d[1]) {
+				f(cx+d[0], cy+d[1])
+			}
+		}
+	}
+}
+
+// 从(px, py)跳到(cx, cy)后沿同一方向继续跳，返回遇到的跳点，(ex, ey)为终点
+func (g *NavGrid) jump(cx, cy, px, py, ex, ey int) (int, int, bool) {
+	dx, dy := cx-px, cy-py
+	for {
+		if !g.Walkable(cx, cy) {
+			return 0, 0, false
+		}
+		if cx == ex && cy == ey {
+			return cx, cy, true
+		}
+		switch {
+		case dx != 0 && dy != 0:
+			// 斜向跳跃时，水平或垂直方向能跳到跳点，当前格子就是跳点
+			if _, _, ok := g.jump(cx+dx, cy, cx, cy, ex, ey); ok {
+				return cx, cy, true
+			}
+			if _, _, ok := g.jump(cx, cy+dy, cx, cy, ex, ey); ok {
+				return cx, cy, true
+			}
+		case dx != 0:
+			// 旁边的格子从被挡住变为能行走，有强制邻居
+			if g.Walkable(cx, cy+1) && !g.Walkable(cx-dx, cy+1) || g.Walkable(cx, cy-1) && !g.Walkable(cx-dx, cy-1) {
+				return cx, cy, true
+			}
+		default:
+			if g.Walkable(cx+1, cy) && !g.Walkable(cx+1, cy-dy) || g.Walkable(cx-1, cy) && !g.Walkable(cx-1, cy-dy) {
+				return cx, cy, true
+			}
+		}
+		if !g.canStep(cx, cy, dx, dy) {
+			return 0, 0, false
+		}
+		cx, cy = cx+dx, cy+dy
+	}
+}
+
+// 平滑路径：从当前位置起跳过能直线到达且代价不更高的路点，只保留拐点
+func (g *NavGrid) SmoothPath(from Position, path []Position) []Position {
+	if len(path) <= 1 {
+		return path
+	}
+	var smoothed []Position
+	anchor := from
+	// 从anchor沿平滑后的路径走到path[i]的代价
+	section, _ := g.lineCost(from, path[0])
+	for i := 0; i < len(path)-1; i++ {
+		step, _ := g.lineCost(path[i], path[i+1])
+		if cost, ok := g.lineCost(anchor, path[i+1]); ok && cost <= section+step+1e-3 {
+			section = cost
+			continue
+		}
+		smoothed = append(smoothed, path[i])
+		anchor = path[i]
+		section = step
+	}
+	return append(smoothed, path[len(path)-1])
+}
+
+// 判断两点之间能否直线行走，经过的格子都要能行走，恰好经过格子的顶点时两侧的格子也要能行走
+func (g *NavGrid) LineWalkable(from, to Position) bool {
+	_, ok := g.lineCost(from, to)
+	return ok
+}
+
+// 两点之间直线行走的代价，按在每个格子中经过的长度（以格子大小为单位）乘以格子代价累加，不能直线行走时返回false
+func (g *NavGrid) lineCost(from, to Position) (float32, bool) {
+	size := float64(g.cellSize)
+	x0, y0 := float64(from.x-g.minX)/size, float64(from.y-g.minY)/size
+	x1, y1 := float64(to.x-g.minX)/size, float64(to.y-g.minY)/size
+	cx, cy := g.Cell(from)
+	ex, ey := g.Cell(to)
+	if !g.Walkable(cx, cy) {
+		return 0, false
+	}
+
+	stepX, tMaxX, tDeltaX := ddaAxis(x0, x1)
+	stepY, tMaxY, tDeltaY := ddaAxis(y0, y1)
+	length := math.Hypot(x1-x0, y1-y0)
+	var cost, t float64
+
+	for n := absInt(ex-cx) + absInt(ey-cy); n > 0; n-- {
+		switch {
+		case tMaxX < tMaxY:
+			cost += (tMaxX - t) * length * float64(g.cost(cx, cy))
+			t = tMaxX
+			tMaxX += tDeltaX
+			cx += stepX
+		case tMaxY < tMaxX:
+			cost += (tMaxY - t) * length * float64(g.cost(cx, cy))
+			t = tMaxY
+			tMaxY += tDeltaY
+			cy += stepY
+		default:
+			// 经过顶点，不能穿过墙角
+			if !g.canStep(cx, cy, stepX, stepY) {
+				return 0, false
+			}
+			cost += (tMaxX - t) * length * float64(g.cost(cx, cy))
+			t = tMaxX
+			tMaxX += tDeltaX
+			tMaxY += tDeltaY
+			cx += stepX
+			cy += stepY
+			n--
+		}
+		if !g.Walkable(cx, cy) {
+			return 0, false
+		}
+	}
+	if cx != ex || cy != ey {
+		return 0, false
+	}
+	cost += max(1-t, 0) * length * float64(g.cost(cx, cy))
+	return float32(cost), true
+}
+
+func sign(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	default:
+		return 0
+	}
+}
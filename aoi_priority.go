@@ -0,0 +1,148 @@
+package aoi
+
+import (
+	"math"
+	"sort"
+)
+
+/*
+视野上限：实体密集时，每个观察者最多只看到优先级最高的capacity个实体。
+观察者自身移动或Refresh时，按优先级重新选择视野内的实体；
+其他实体移动时增量调整：离开视野空出的位置由剩余候选中优先级最高的补上，新进入范围的实体优先级更高时替换视野内优先级最低的实体，
+视野已满时移动的实体优先级降到视野内其他实体之下，且剩余候选中优先级最高的实体超过它时，两者交换。
+*/
+
+// 优先级，数值越大越优先进入视野
+type PriorityFunc func(watcher, target Entity) float32
+
+// 设置视野内实体数量上限，priority为空时距离越近越优先
+func WithCapacity(capacity int, priority PriorityFunc) Option {
+	return func(m *AOIManager) {
+		m.capacity = capacity
+		m.priority = priority
+	}
+}
+
+// 获取实体对观察者的优先级
+func (m *AOIManager) getPriority(w, n *aoiNode) float32 {
+	if m.priority != nil {
+		return m.priority(w.entity, n.entity)
+	}
+	return -distSq(w.pos, n.pos)
+}
+
+// 按优先级重新选择视野内的实体
+func (m *AOIManager) selectWatching(n *aoiNode) {
+	// 收集所有可见的实体，包括已在视野内的
+	var list []*aoiNode
+	for _, other := range n.watching {
+		if m.canSee(n, other) {
+			list = append(list, other)
+		}
+	}
+	m.visitCandidates(n, func(other *aoiNode) {
+		if _, ok := n.watching[other.entity.ID()]; !ok && other != n && m.canSee(n, other) {
+			list = append(list, other)
+		}
+	})
+
+	// 选出优先级最高的实体
+	if len(list) > m.capacity {
+		priority := make(map[*aoiNode]float32, len(list))
+		for _, other := range list {
+			priority[other] = m.getPriority(n, other)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return priority[list[i]] > priority[list[j]]
+		})
+		list = list[:m.capacity]
+	}
+	selected := make(map[int]*aoiNode, len(list))
+	for _, other := range list {
+		selected[other.entity.ID()] = other
+	}
+
+	// 更新视野
+	for id, other := range n.watching {
+		if _, ok := selected[id]; !ok {
+			n.unwatch(m, other)
+		}
+	}
+	for id, other := range selected {
+		if _, ok := n.watching[id]; !ok {
+			n.watch(m, other)
+		}
+	}
+}
+
+// 更新能看到实体的观察者，观察者的视野已满时按优先级替换
+func (m *AOIManager) updateLimitedWatchers(n *aoiNode, fromPos, toPos Position) {
+	for _, w := range n.watchers {
+		if m.canSee(w, n) {
+			// 视野已满且实体的优先级降到视野内其他实体之下时，可能被候选中的实体超过；
+			// 否则候选的优先级都不高于视野内的实体，视野不变
+			if len(w.watching) >= m.capacity {
+				p := m.getPriority(w, n)
+				if _, lowestPriority := m.lowestWatching(w, n); p < lowestPriority {
+					if best, bestPriority := m.bestCandidate(w); best != nil && bestPriority > p {
+						w.unwatch(m, n)
+						w.watch(m, best)
+					}
+				}
+			}
+			if _, ok := w.watching[n.entity.ID()]; ok && fromPos != toPos {
+				m.notifyMove(w, n, fromPos, toPos)
+			}
+			continue
+		}
+		// 离开视野，空出的位置由剩余候选中优先级最高的补上
+		w.unwatch(m, n)
+		if best, _ := m.bestCandidate(w); best != nil {
+			w.watch(m, best)
+		}
+	}
+	for id, w := range m.posToGrid(toPos).watchers {
+		if _, ok := n.watchers[id]; ok || w == n || !m.canSee(w, n) {
+			continue
+		}
+		if len(w.watching) < m.capacity {
+			w.watch(m, n)
+			continue
+		}
+		// 替换优先级最低的实体
+		if lowest, lowestPriority := m.lowestWatching(w, nil); m.getPriority(w, n) > lowestPriority {
+			w.unwatch(m, lowest)
+			w.watch(m, n)
+		}
+	}
+}
+
+// 观察者视野内除except外优先级最低的实体，没有时优先级为正无穷
+func (m *AOIManager) lowestWatching(w, except *aoiNode) (*aoiNode, float32) {
+	var lowest *aoiNode
+	lowestPriority := float32(math.Inf(1))
+	for _, other := range w.watching {
+		if other == except {
+			continue
+		}
+		if p := m.getPriority(w, other); lowest == nil || p < lowestPriority {
+			lowest, lowestPriority = other, p
+		}
+	}
+	return lowest, lowestPriority
+}
+
+// 观察者视野外优先级最高的可见候选实体，没有时返回nil
+func (m *AOIManager) bestCandidate(w *aoiNode) (*aoiNode, float32) {
+	var best *aoiNode
+	var bestPriority float32
+	m.visitCandidates(w, func(other *aoiNode) {
+		if _, ok := w.watching[other.entity.ID()]; ok || other == w || !m.canSee(w, other) {
+			return
+		}
+		if p := m.getPriority(w, other); best == nil || p > bestPriority {
+			best, bestPriority = other, p
+		}
+	})
+	return best, bestPriority
+}
@@ -0,0 +1,289 @@
+package aoi
+
+/*
+四叉树AOI：叶子节点中的实体数量超过容量时，把区域等分为四个子区域；子树中的实体数量降到容量一半时，再合并回叶子节点。
+只有实体所在的区域才会被细分，内存与实体数量相关，与地图面积无关，适合超大且稀疏的地图。
+*/
+
+const quadMaxDepth = 16 // 最大深度，防止实体重叠时无限细分
+
+// 四叉树实体
+type quadEntity struct {
+	*aoiNode
+	pos  Position  // 实体在树中的坐标
+	leaf *quadNode // 所在的叶子节点
+}
+
+// 四叉树节点
+type quadNode struct {
+	minX, maxX, minY, maxY float32             // 区域范围
+	depth                  int                 // 深度
+	count                  int                 // 子树中的实体数量
+	children               []*quadNode         // 子节点，叶子节点为空
+	entitys                map[int]*quadEntity // 叶子节点中的实体
+}
+
+// 四叉树
+type QuadTreeAOI struct {
+	directNotifier
+	radius   float32             // 默认视野半径
+	capacity int                 // 叶子节点容量
+	root     *quadNode           // 根节点
+	nodes    map[int]*quadEntity // 地图中的实体
+	ranges   viewRanges          // 视野范围统计
+}
+
+// 创建四叉树，capacity小于1时按1处理，否则每次插入都会分裂到最大深度
+func NewQuadTreeAOI(minX, maxX, minY, maxY float32, radius float32, capacity int) *QuadTreeAOI {
+	return &QuadTreeAOI{
+		radius:   radius,
+		capacity: max(capacity, 1),
+		root:     newQuadNode(minX, maxX, minY, maxY, 0),
+		nodes:    make(map[int]*quadEntity),
+	}
+}
+
+// 创建四叉树节点
+func newQuadNode(minX, maxX, minY, maxY float32, depth int) *quadNode {
+	return &quadNode{
+		minX:    minX,
+		maxX:    maxX,
+		minY:    minY,
+		maxY:    maxY,
+		depth:   depth,
+		entitys: make(map[int]*quadEntity),
+	}
+}
+
+// 进入地图
+func (q *QuadTreeAOI) Enter(entity Entity, pos Position) {
+	entity.SetPos(pos)
+	e := &quadEntity{aoiNode: newAOINode(entity), pos: pos}
+	q.nodes[entity.ID()] = e
+	e.viewRange = viewRangeOf(entity, q.radius)
+	q.ranges.add(e.viewRange)
+	q.insert(e)
+	// 更新视野
+	q.updateWatchers(e, pos, pos)
+	q.updateWatching(e)
+}
+
+// 离开地图
+func (q *QuadTreeAOI) Leave(entity Entity) {
+	e, ok := q.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	delete(q.nodes, entity.ID())
+	q.ranges.remove(e.viewRange)
+	q.remove(e)
+	e.clear(q)
+}
+
+// 移动
+func (q *QuadTreeAOI) Move(entity Entity, toPos Position) {
+	e, ok := q.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	fromPos := entity.GetPos()
+	entity.SetPos(toPos)
+	q.ranges.remove(e.viewRange)
+	e.viewRange = viewRangeOf(entity, q.radius)
+	q.ranges.add(e.viewRange)
+	// 离开所在的叶子节点时，重新插入
+	if q.leafOf(toPos) == e.leaf {
+		e.pos = toPos
+	} else {
+		q.remove(e)
+		e.pos = toPos
+		q.insert(e)
+	}
+	// 更新视野
+	q.updateWatchers(e, fromPos, toPos)
+	q.updateWatching(e)
+}
+
+// 获取视野内的实体
+func (q *QuadTreeAOI) Watching(entity Entity) []Entity {
+	e, ok := q.nodes[entity.ID()]
+	if !ok {
+		return nil
+	}
+	return e.watchingList()
+}
+
+// 获取能看到实体的观察者
+func (q *QuadTreeAOI) Watchers(entity Entity) []Entity {
+	e, ok := q.nodes[entity.ID()]
+	if !ok {
+		return nil
+	}
+	return e.watcherList()
+}
+
+// 判断观察者能否看到实体
+func (q *QuadTreeAOI) canSee(w, n *aoiNode) bool {
+	return distSq(w.entity.GetPos(), n.entity.GetPos()) <= w.viewRange*w.viewRange
+}
+
+// 更新观察者的视野
+func (q *QuadTreeAOI) updateWatching(e *quadEntity) {
+	e.updateWatching(q, func(f func(*aoiNode)) {
+		q.visitRange(e.pos, e.viewRange, f)
+	})
+}
+
+// 更新能看到实体的观察者
+func (q *QuadTreeAOI) updateWatchers(e *quadEntity, fromPos, toPos Position) {
+	e.updateWatchers(q, func(f func(*aoiNode)) {
+		q.visitRange(e.pos, q.ranges.max, f)
+	}, fromPos, toPos)
+}
+
+// 遍历与pos的x、y坐标之差都不超过r的实体
+func (q *QuadTreeAOI) visitRange(pos Position, r float32, f func(*aoiNode)) {
+	// 树中的实体坐标经过限制，查询范围也要限制
+	min := q.clamp(Position{x: pos.x - r, y: pos.y - r})
+	max := q.clamp(Position{x: pos.x + r, y: pos.y + r})
+	q.visitNode(q.root, min, max, pos, r, f)
+}
+
+// 递归遍历节点
+func (q *QuadTreeAOI) visitNode(node *quadNode, min, max, pos Position, r float32, f func(*aoiNode)) {
+	if node.count == 0 || !node.intersects(min.x, max.x, min.y, max.y) {
+		return
+	}
+	for _, child := range node.children {
+		q.visitNode(child, min, max, pos, r, f)
+	}
+	for _, e := range node.entitys {
+		dx, dy := e.pos.x-pos.x, e.pos.y-pos.y
+		if dx >= -r && dx <= r && dy >= -r && dy <= r {
+			f(e.aoiNode)
+		}
+	}
+}
+
+// 插入实体，叶子节点超过容量时细分
+func (q *QuadTreeAOI) insert(e *quadEntity) {
+	pos := q.clamp(e.pos)
+	node := q.root
+	for node.children != nil {
+		node.count++
+		node = node.child(pos)
+	}
+	node.count++
+	node.entitys[e.entity.ID()] = e
+	e.leaf = node
+	if node.count > q.capacity && node.depth < quadMaxDepth {
+		q.split(node)
+	}
+}
+
+// 移除实体，子树中的实体数量降到容量一半时合并
+func (q *QuadTreeAOI) remove(e *quadEntity) {
+	pos := q.clamp(e.pos)
+	node := q.root
+	for {
+		node.count--
+		if node.children == nil {
+			break
+		}
+		if node.count <= q.capacity/2 {
+			q.merge(node)
+			break
+		}
+		node = node.child(pos)
+	}
+	delete(node.entitys, e.entity.ID())
+	e.leaf = nil
+}
+
+// 细分叶子节点
+func (q *QuadTreeAOI) split(node *quadNode) {
+	midX := (node.minX + node.maxX) / 2
+	midY := (node.minY + node.maxY) / 2
+	node.children = []*quadNode{
+		newQuadNode(node.minX, midX, node.minY, midY, node.depth+1),
+		newQuadNode(midX, node.maxX, node.minY, midY, node.depth+1),
+		newQuadNode(node.minX, midX, midY, node.maxY, node.depth+1),
+		newQuadNode(midX, node.maxX, midY, node.maxY, node.depth+1),
+	}
+	entitys := node.entitys
+	node.entitys = make(map[int]*quadEntity)
+	for _, e := range entitys {
+		child := node.child(q.clamp(e.pos))
+		child.count++
+		child.entitys[e.entity.ID()] = e
+		e.leaf = child
+	}
+	for _, child := range node.children {
+		if child.count > q.capacity && child.depth < quadMaxDepth {
+			q.split(child)
+		}
+	}
+}
+
+// 合并子树为叶子节点
+func (q *QuadTreeAOI) merge(node *quadNode) {
+	var collect func(*quadNode)
+	collect = func(child *quadNode) {
+		for _, c := range child.children {
+			collect(c)
+		}
+		for id, e := range child.entitys {
+			node.entitys[id] = e
+			e.leaf = node
+		}
+	}
+	for _, child := range node.children {
+		collect(child)
+	}
+	node.children = nil
+}
+
+// 获取坐标所在的叶子节点
+func (q *QuadTreeAOI) leafOf(pos Position) *quadNode {
+	pos = q.clamp(pos)
+	node := q.root
+	for node.children != nil {
+		node = node.child(pos)
+	}
+	return node
+}
+
+// 把坐标限制在地图范围内
+func (q *QuadTreeAOI) clamp(pos Position) Position {
+	root := q.root
+	if pos.x < root.minX {
+		pos.x = root.minX
+	} else if pos.x > root.maxX {
+		pos.x = root.maxX
+	}
+	if pos.y < root.minY {
+		pos.y = root.minY
+	} else if pos.y > root.maxY {
+		pos.y = root.maxY
+	}
+	return pos
+}
+
+// 获取坐标所在的子节点
+func (node *quadNode) child(pos Position) *quadNode {
+	midX := (node.minX + node.maxX) / 2
+	midY := (node.minY + node.maxY) / 2
+	i := 0
+	if pos.x >= midX {
+		i |= 1
+	}
+	if pos.y >= midY {
+		i |= 2
+	}
+	return node.children[i]
+}
+
+// 判断区域是否与矩形相交
+func (node *quadNode) intersects(minX, maxX, minY, maxY float32) bool {
+	return minX <= node.maxX && maxX >= node.minX && minY <= node.maxY && maxY >= node.minY
+}
@@ -0,0 +1,97 @@
+package aoi
+
+import (
+	"sort"
+)
+
+// 查询矩形范围内的实体
+func (m *AOIManager) QueryRect(minX, maxX, minY, maxY float32) []Entity {
+	var list []Entity
+	xmin, ymin := m.transXY(minX, minY)
+	xmax, ymax := m.transXY(maxX, maxY)
+	m.visitGrids(xmin, xmax, ymin, ymax, func(g *Grid) {
+		for _, n := range g.entitys {
+			pos := n.pos
+			if pos.x >= minX && pos.x <= maxX && pos.y >= minY && pos.y <= maxY {
+				list = append(list, n.entity)
+			}
+		}
+	})
+	return list
+}
+
+// 查询圆形范围内的实体
+func (m *AOIManager) QueryRadius(pos Position, radius float32) []Entity {
+	var list []Entity
+	xmin, ymin := m.transXY(pos.x-radius, pos.y-radius)
+	xmax, ymax := m.transXY(pos.x+radius, pos.y+radius)
+	m.visitGrids(xmin, xmax, ymin, ymax, func(g *Grid) {
+		for _, n := range g.entitys {
+			if distSq(pos, n.pos) <= radius*radius {
+				list = append(list, n.entity)
+			}
+		}
+	})
+	return list
+}
+
+// 查询离pos最近的k个实体，按距离从近到远排列，filter为空时不过滤
+func (m *AOIManager) Nearest(pos Position, k int, filter func(Entity) bool) []Entity {
+	if k <= 0 {
+		return nil
+	}
+	type candidate struct {
+		entity Entity
+		dist   float32 // 距离的平方
+	}
+	var list []candidate
+	cx, cy := m.transXY(pos.x, pos.y)
+	maxRing := max(cx, m.xNum-1-cx, cy, m.yNum-1-cy)
+	// 从所在网格开始逐圈向外查找
+	for d := 0; d <= maxRing; d++ {
+		// 第d圈的实体距离不小于(d-1)*gsize，已找到的k个实体都更近时停止
+		bound := float32(d-1) * m.gsize
+		if len(list) >= k && bound > 0 && list[k-1].dist <= bound*bound {
+			break
+		}
+		m.visitRing(cx, cy, d, func(g *Grid) {
+			for _, n := range g.entitys {
+				if filter != nil && !filter(n.entity) {
+					continue
+				}
+				list = append(list, candidate{n.entity, distSq(pos, n.pos)})
+			}
+		})
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].dist < list[j].dist
+		})
+	}
+
+	if len(list) > k {
+		list = list[:k]
+	}
+	res := make([]Entity, len(list))
+	for i, c := range list {
+		res[i] = c.entity
+	}
+	return res
+}
+
+// 遍历以(cx, cy)为中心的第d圈网格
+func (m *AOIManager) visitRing(cx, cy, d int, f func(*Grid)) {
+	for x := cx - d; x <= cx+d; x++ {
+		if x < 0 || x >= m.xNum {
+			continue
+		}
+		// 中间的列只有上下两个网格在圈上
+		step := 1
+		if x != cx-d && x != cx+d {
+			step = 2 * d
+		}
+		for y := cy - d; y <= cy+d; y += step {
+			if y >= 0 && y < m.yNum {
+				f(&m.grids[x][y])
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package aoi
+
+/*
+触发区域：陷阱、安全区、任务区域等静态区域，注册后记录在与其相交的网格中。
+实体进入、移动、离开时，只检查所在网格中的区域，增量计算进出区域的变化。
+只有会被观察的实体（ModeWatched）参与区域判断。
+*/
+
+// 可选接口，进出区域时回调
+type RegionWatcher interface {
+	OnRegionEnter(r *Region)
+	OnRegionExit(r *Region)
+}
+
+// 区域形状
+type Shape interface {
+	Contains(pos Position) bool               // 判断坐标是否在形状内
+	Bounds() (minX, maxX, minY, maxY float32) // 外接矩形
+}
+
+// 矩形
+type Rect struct {
+	MinX, MaxX, MinY, MaxY float32
+}
+
+func (r Rect) Contains(pos Position) bool {
+	return pos.x >= r.MinX && pos.x <= r.MaxX && pos.y >= r.MinY && pos.y <= r.MaxY
+}
+
+func (r Rect) Bounds() (float32, float32, float32, float32) {
+	return r.MinX, r.MaxX, r.MinY, r.MaxY
+}
+
+// 多边形，顶点按顺序排列
+type Polygon []Position
+
+// 射线法判断坐标是否在多边形内
+func (p Polygon) Contains(pos Position) bool {
+	in := false
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		a, b := p[i], p[j]
+		if (a.y > pos.y) != (b.y > pos.y) && pos.x < (b.x-a.x)*(pos.y-a.y)/(b.y-a.y)+a.x {
+			in = !in
+		}
+	}
+	return in
+}
+
+func (p Polygon) Bounds() (minX, maxX, minY, maxY float32) {
+	for i, pos := range p {
+		if i == 0 || pos.x < minX {
+			minX = pos.x
+		}
+		if i == 0 || pos.x > maxX {
+			maxX = pos.x
+		}
+		if i == 0 || pos.y < minY {
+			minY = pos.y
+		}
+		if i == 0 || pos.y > maxY {
+			maxY = pos.y
+		}
+	}
+	return
+}
+
+// 区域
+type Region struct {
+	id      int
+	shape   Shape
+	members map[int]*aoiNode // 区域内的实体
+}
+
+// 区域id
+func (r *Region) ID() int {
+	return r.id
+}
+
+// 区域形状
+func (r *Region) Shape() Shape {
+	return r.shape
+}
+
+// 添加区域，已在区域内的实体会收到进入回调
+func (m *AOIManager) AddRegion(id int, shape Shape) *Region {
+	m.RemoveRegion(id)
+	r := &Region{id: id, shape: shape, members: make(map[int]*aoiNode)}
+	m.regions[id] = r
+	m.visitRegionGrids(r, func(g *Grid) {
+		g.regions[id] = r
+		for _, n := range g.entitys {
+			if shape.Contains(n.pos) {
+				m.enterRegion(n, r)
+			}
+		}
+	})
+	return r
+}
+
+// 移除区域，区域内的实体会收到离开回调
+func (m *AOIManager) RemoveRegion(id int) {
+	r, ok := m.regions[id]
+	if !ok {
+		return
+	}
+	delete(m.regions, id)
+	m.visitRegionGrids(r, func(g *Grid) {
+		delete(g.regions, id)
+	})
+	for _, n := range r.members {
+		m.exitRegion(n, r)
+	}
+}
+
+// 获取区域内的实体
+func (m *AOIManager) RegionMembers(id int) []Entity {
+	r, ok := m.regions[id]
+	if !ok {
+		return nil
+	}
+	list := make([]Entity, 0, len(r.members))
+	for _, n := range r.members {
+		list = append(list, n.entity)
+	}
+	return list
+}
+
+// 更新实体所在的区域
+func (m *AOIManager) updateRegions(n *aoiNode) {
+	pos := n.pos
+	for _, r := range n.regions {
+		if !r.shape.Contains(pos) {
+			m.exitRegion(n, r)
+		}
+	}
+	for id, r := range m.posToGrid(pos).regions {
+		if _, ok := n.regions[id]; !ok && r.shape.Contains(pos) {
+			m.enterRegion(n, r)
+		}
+	}
+}
+
+// 离开所有区域
+func (m *AOIManager) exitRegions(n *aoiNode) {
+	for _, r := range n.regions {
+		m.exitRegion(n, r)
+	}
+}
+
+// 进入区域
+func (m *AOIManager) enterRegion(n *aoiNode, r *Region) {
+	if n.regions == nil {
+		n.regions = make(map[int]*Region)
+	}
+	n.regions[r.id] = r
+	r.members[n.entity.ID()] = n
+	if rw, ok := n.entity.(RegionWatcher); ok {
+		rw.OnRegionEnter(r)
+	}
+}
+
+// 离开区域
+func (m *AOIManager) exitRegion(n *aoiNode, r *Region) {
+	delete(n.regions, r.id)
+	delete(r.members, n.entity.ID())
+	if rw, ok := n.entity.(RegionWatcher); ok {
+		rw.OnRegionExit(r)
+	}
+}
+
+// 遍历与区域相交的网格
+func (m *AOIManager) visitRegionGrids(r *Region, f func(*Grid)) {
+	minX, maxX, minY, maxY := r.shape.Bounds()
+	xmin, ymin := m.transXY(minX, minY)
+	xmax, ymax := m.transXY(maxX, maxY)
+	m.visitGrids(xmin, xmax, ymin, ymax, f)
+}
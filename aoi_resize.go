@@ -0,0 +1,55 @@
+package aoi
+
+/*
+调整地图：运行时修改地图范围和网格大小，如活动期间扩大可活动区域、按负载调整网格大小。
+在原地重建网格，再按ReasonRefresh原因更新每个观察者的视野，只回调可见性发生变化的实体。
+*/
+
+// 调整地图范围和网格大小，新范围外的实体限制到边界网格
+func (m *AOIManager) Reconfigure(minX, maxX, minY, maxY float32, gsize float32) {
+	m.minX, m.maxX, m.minY, m.maxY = minX, maxX, minY, maxY
+	m.gsize = gsize
+	m.xNum = int((maxX-minX)/gsize) + 1
+	m.yNum = int((maxY-minY)/gsize) + 1
+
+	// 重建网格
+	m.grids = make([][]Grid, m.xNum)
+	for i := 0; i < m.xNum; i++ {
+		m.grids[i] = make([]Grid, m.yNum)
+		for j := 0; j < m.yNum; j++ {
+			m.grids[i][j].init()
+		}
+	}
+	for id, n := range m.nodes {
+		if n.mode&ModeWatched != 0 {
+			m.posToGrid(n.pos).entitys[id] = n
+		}
+		if n.mode&ModeWatcher != 0 {
+			// 旧网格已丢弃，直接从空范围开始观察；默认视野范围随网格大小变化
+			n.xmin, n.xmax, n.ymin, n.ymax = 0, -1, 0, -1
+			n.viewRange = m.getViewRange(n.entity)
+			xmin, xmax, ymin, ymax := m.getWatchGrids(n.pos, n.viewRange)
+			m.setWatchGrids(n, xmin, xmax, ymin, ymax)
+		}
+	}
+	for id, r := range m.regions {
+		m.visitRegionGrids(r, func(g *Grid) {
+			g.regions[id] = r
+		})
+	}
+
+	// 更新视野
+	m.reason = ReasonRefresh
+	for _, n := range m.nodes {
+		if n.mode&ModeWatcher != 0 {
+			m.updateWatching(n)
+		}
+	}
+	if m.tierBounds != nil {
+		for _, n := range m.nodes {
+			for _, other := range n.watching {
+				m.changeTier(n, other)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package aoi
+
+import (
+	"fmt"
+)
+
+/*
+AOI服务：独占一个AOI实现，在单独的协程中按顺序执行所有命令，实体回调也都在该协程中执行。
+其他协程通过channel提交命令，无需加锁；每个场景一个AOI服务，不同场景可以并行。
+与chanrpc类似，支持异步提交（Go）和同步调用（Call）两种模式。
+*/
+
+// AOI服务
+type AOIServer struct {
+	aoi     AOI           // 独占的AOI实现
+	chanCmd chan *aoiCmd  // 命令
+	done    chan struct{} // 协程退出
+}
+
+// 命令
+type aoiCmd struct {
+	f       func(AOI)  // 执行函数
+	chanRet chan error // 同步调用的返回，异步时为空
+}
+
+// 创建AOI服务，创建后aoi只能通过服务访问
+func NewAOIServer(aoi AOI, size int) *AOIServer {
+	return &AOIServer{
+		aoi:     aoi,
+		chanCmd: make(chan *aoiCmd, size),
+		done:    make(chan struct{}),
+	}
+}
+
+// 启动服务
+func (s *AOIServer) Start() {
+	go func() {
+		for cmd := range s.chanCmd {
+			s.exec(cmd)
+		}
+		close(s.done)
+	}()
+}
+
+// 停止服务，执行完已提交的命令后返回，之后不能再提交命令
+func (s *AOIServer) Stop() {
+	close(s.chanCmd)
+	<-s.done
+}
+
+// 执行命令
+func (s *AOIServer) exec(cmd *aoiCmd) {
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+		if cmd.chanRet != nil {
+			cmd.chanRet <- err
+		}
+	}()
+	cmd.f(s.aoi)
+}
+
+// 异步执行，忽略错误
+func (s *AOIServer) Go(f func(AOI)) {
+	s.chanCmd <- &aoiCmd{f: f}
+}
+
+// 同步执行，等待执行完成
+func (s *AOIServer) Call(f func(AOI)) error {
+	chanRet := make(chan error, 1)
+	s.chanCmd <- &aoiCmd{f: f, chanRet: chanRet}
+	return <-chanRet
+}
+
+// 进入地图，异步执行
+func (s *AOIServer) Enter(entity Entity, pos Position) {
+	s.Go(func(aoi AOI) {
+		aoi.Enter(entity, pos)
+	})
+}
+
+// 离开地图，异步执行
+func (s *AOIServer) Leave(entity Entity) {
+	s.Go(func(aoi AOI) {
+		aoi.Leave(entity)
+	})
+}
+
+// 移动，异步执行
+func (s *AOIServer) Move(entity Entity, pos Position) {
+	s.Go(func(aoi AOI) {
+		aoi.Move(entity, pos)
+	})
+}
+
+// 获取视野内的实体，同步执行，AOI执行出错时返回错误
+func (s *AOIServer) Watching(entity Entity) ([]Entity, error) {
+	var list []Entity
+	err := s.Call(func(aoi AOI) {
+		list = aoi.Watching(entity)
+	})
+	return list, err
+}
+
+// 获取能看到实体的观察者，同步执行，AOI执行出错时返回错误
+func (s *AOIServer) Watchers(entity Entity) ([]Entity, error) {
+	var list []Entity
+	err := s.Call(func(aoi AOI) {
+		list = aoi.Watchers(entity)
+	})
+	return list, err
+}
@@ -0,0 +1,414 @@
+package aoi
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+/*
+分片地图：把地图按行列切分为多个分片，每个分片由一个AOI服务在单独的协程中管理，不同分片并行执行。
+实体由所在分片管理，同时以镜像（ghost）的形式加入边界范围覆盖它的其他分片，
+镜像只被观察、不观察其他实体，使相邻分片的观察者能看到边界另一侧的实体。
+实体跨越分片时交接：原分片把实体降为镜像并交出视野，新分片把镜像升为实体并重新计算视野，
+只回调视野的变化，进出视野的回调与单个管理相同。
+
+边界宽度border不能小于最大视野范围加离开视野的缓冲距离，会向上取整为网格大小的整数倍，使分片的网格与整个地图对齐。
+只支持WithRadius、WithHysteresis、WithVerticalRange、WithCanSee、WithBlockMap选项，其他选项在创建时返回错误；
+可见性判断的条件或阻挡变化后需调用ShardedAOI.Refresh，在实体及其镜像所在的分片中重新判断。
+回调在各分片的协程中执行：同一个实体的回调不会并发，但不同实体的回调会，CanSeeFunc等也会在多个协程中同时调用。
+其他分片的实体在回调中以*Ghost传入，GetPos返回镜像在本分片中的坐标；同一实体在不同回调中可能是不同的对象，应按ID比较。
+*/
+
+// 镜像实体，GetPos返回镜像在所在分片中的坐标，避免跨协程读取原实体的坐标
+type Ghost struct {
+	Entity          // 原实体，不能在回调中读取它的坐标
+	pos    Position // 镜像的坐标
+}
+
+func (g *Ghost) GetPos() Position {
+	return g.pos
+}
+
+func (g *Ghost) SetPos(pos Position) {
+	g.pos = pos
+}
+
+// 获取镜像的原实体
+func realEntity(entity Entity) Entity {
+	if g, ok := entity.(*Ghost); ok {
+		return g.Entity
+	}
+	return entity
+}
+
+// 分片
+type aoiShard struct {
+	server                 *AOIServer  // 分片的AOI服务
+	mgr                    *AOIManager // 分片的管理，只在服务协程中访问
+	minX, maxX, minY, maxY float32     // 包括边界的范围
+}
+
+// 判断坐标是否在分片包括边界的范围内
+func (s *aoiShard) contains(pos Position) bool {
+	return pos.x >= s.minX && pos.x <= s.maxX && pos.y >= s.minY && pos.y <= s.maxY
+}
+
+// 分片地图
+type ShardedAOI struct {
+	minX, maxX, minY, maxY float32          // 地图范围
+	width, height          float32          // 分片大小
+	cols, rows             int              // 分片数量
+	border                 float32          // 边界宽度
+	defaultRange           float32          // 默认视野范围
+	hysteresis             float32          // 离开视野的缓冲距离
+	shards                 []*aoiShard      // 分片，按行排列
+	mutex                  sync.Mutex       // 保证各分片按相同顺序收到命令
+	positions              map[int]Position // 实体坐标
+}
+
+// 创建分片地图，cols、rows为分片的列数和行数，边界宽度不足或选项不支持时返回错误
+func NewShardedAOI(minX, maxX, minY, maxY float32, gsize float32, cols, rows int, border float32, opts ...Option) (*ShardedAOI, error) {
+	if gsize <= 0 || cols < 1 || rows < 1 {
+		return nil, fmt.Errorf("invalid shard layout: gsize %v, %dx%d", gsize, cols, rows)
+	}
+	// 检查选项
+	probe := NewAOIManager(minX, minX, minY, minY, gsize, opts...)
+	if probe.batch != nil || probe.capacity > 0 || probe.tierBounds != nil || probe.outOfBounds != nil {
+		return nil, fmt.Errorf("sharded aoi does not support batch, capacity, tiers or bounds check")
+	}
+
+	// 分片大小和边界宽度按网格对齐
+	align := func(v float32) float32 {
+		return float32(math.Ceil(float64(v/gsize))) * gsize
+	}
+	s := &ShardedAOI{
+		minX:         minX,
+		maxX:         maxX,
+		minY:         minY,
+		maxY:         maxY,
+		width:        align((maxX - minX) / float32(cols)),
+		height:       align((maxY - minY) / float32(rows)),
+		cols:         cols,
+		rows:         rows,
+		border:       align(border),
+		defaultRange: probe.getViewRange(nil),
+		hysteresis:   probe.hysteresis,
+		positions:    make(map[int]Position),
+	}
+	if s.defaultRange+s.hysteresis > s.border {
+		return nil, fmt.Errorf("shard border %v less than view range %v plus hysteresis %v", s.border, s.defaultRange, s.hysteresis)
+	}
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			shard := &aoiShard{
+				minX: max(minX, minX+float32(i)*s.width-s.border),
+				maxX: min(maxX, minX+float32(i+1)*s.width+s.border),
+				minY: max(minY, minY+float32(j)*s.height-s.border),
+				maxY: min(maxY, minY+float32(j+1)*s.height+s.border),
+			}
+			shard.mgr = NewAOIManager(shard.minX, shard.maxX, shard.minY, shard.maxY, gsize, opts...)
+			shard.server = NewAOIServer(shard.mgr, 1024)
+			s.shards = append(s.shards, shard)
+		}
+	}
+	return s, nil
+}
+
+// 启动所有分片
+func (s *ShardedAOI) Start() {
+	for _, shard := range s.shards {
+		shard.server.Start()
+	}
+}
+
+// 停止所有分片，执行完已提交的命令后返回
+func (s *ShardedAOI) Stop() {
+	for _, shard := range s.shards {
+		shard.server.Stop()
+	}
+}
+
+// 等待所有分片执行完已提交的命令
+func (s *ShardedAOI) Wait() {
+	for _, shard := range s.shards {
+		shard.server.Call(func(AOI) {})
+	}
+}
+
+// 检查实体的视野范围，超出边界宽度时相邻分片的镜像不完整
+func (s *ShardedAOI) checkViewRange(entity Entity) error {
+	if r := viewRangeOf(entity, s.defaultRange); r+s.hysteresis > s.border {
+		return fmt.Errorf("entity %d view range %v plus hysteresis %v exceeds shard border %v", entity.ID(), r, s.hysteresis, s.border)
+	}
+	return nil
+}
+
+// 把坐标限制到地图范围内
+func (s *ShardedAOI) clamp(pos Position) Position {
+	pos.x = min(max(pos.x, s.minX), s.maxX)
+	pos.y = min(max(pos.y, s.minY), s.maxY)
+	return pos
+}
+
+// 获取管理坐标的分片
+func (s *ShardedAOI) shardOf(pos Position) *aoiShard {
+	pos = s.clamp(pos)
+	i := min(int((pos.x-s.minX)/s.width), s.cols-1)
+	j := min(int((pos.y-s.minY)/s.height), s.rows-1)
+	return s.shards[j*s.cols+i]
+}
+
+// 获取边界范围覆盖坐标的分片，包括管理坐标的分片
+func (s *ShardedAOI) shardsNear(pos Position) map[*aoiShard]bool {
+	pos = s.clamp(pos)
+	near := make(map[*aoiShard]bool)
+	for _, shard := range s.shards {
+		if shard.contains(pos) {
+			near[shard] = true
+		}
+	}
+	return near
+}
+
+// 进入地图，异步执行，视野范围超出边界宽度时拒绝并返回错误
+func (s *ShardedAOI) Enter(entity Entity, pos Position) error {
+	if err := s.checkViewRange(entity); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.positions[entity.ID()] = pos
+	owner := s.shardOf(pos)
+	for shard := range s.shardsNear(pos) {
+		mgr := shard.mgr
+		if shard == owner {
+			shard.server.Go(func(AOI) {
+				mgr.Enter(entity, pos)
+			})
+		} else {
+			shard.server.Go(func(AOI) {
+				mgr.enterGhost(entity, pos, ReasonEnter)
+			})
+		}
+	}
+	return nil
+}
+
+// 离开地图，异步执行
+func (s *ShardedAOI) Leave(entity Entity) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pos, ok := s.positions[entity.ID()]
+	if !ok {
+		return
+	}
+	delete(s.positions, entity.ID())
+	for shard := range s.shardsNear(pos) {
+		mgr := shard.mgr
+		shard.server.Go(func(AOI) {
+			mgr.Leave(entity)
+		})
+	}
+}
+
+// 移动，异步执行，视野范围超出边界宽度时拒绝并返回错误
+func (s *ShardedAOI) Move(entity Entity, toPos Position) error {
+	if err := s.checkViewRange(entity); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fromPos, ok := s.positions[entity.ID()]
+	if !ok {
+		return nil
+	}
+	s.positions[entity.ID()] = toPos
+	from, to := s.shardOf(fromPos), s.shardOf(toPos)
+	oldNear, newNear := s.shardsNear(fromPos), s.shardsNear(toPos)
+
+	// 跨越分片时交接，原分片先降为镜像，新分片等待原分片交出视野
+	var chanHandover chan map[int]Entity
+	if from != to {
+		chanHandover = make(chan map[int]Entity, 1)
+		fromMgr, toMgr := from.mgr, to.mgr
+		from.server.Go(func(AOI) {
+			chanHandover <- fromMgr.demote(entity)
+			if newNear[from] {
+				fromMgr.Move(entity, toPos)
+			} else {
+				fromMgr.leaveGhost(entity, ReasonMove)
+			}
+		})
+		to.server.Go(func(AOI) {
+			if oldNear[to] {
+				toMgr.Move(entity, toPos)
+			} else {
+				toMgr.enterGhost(entity, toPos, ReasonMove)
+			}
+			toMgr.promote(entity, <-chanHandover)
+		})
+	}
+
+	// 其他分片更新镜像
+	for shard := range oldNear {
+		if chanHandover != nil && (shard == from || shard == to) {
+			continue
+		}
+		mgr := shard.mgr
+		if newNear[shard] {
+			shard.server.Go(func(AOI) {
+				mgr.Move(entity, toPos)
+			})
+		} else {
+			shard.server.Go(func(AOI) {
+				mgr.leaveGhost(entity, ReasonMove)
+			})
+		}
+	}
+	for shard := range newNear {
+		if oldNear[shard] || shard == to && chanHandover != nil {
+			continue
+		}
+		mgr := shard.mgr
+		shard.server.Go(func(AOI) {
+			mgr.enterGhost(entity, toPos, ReasonMove)
+		})
+	}
+	return nil
+}
+
+// 重新判断实体与周围实体的可见性，异步执行，实体及其镜像所在的分片都重新判断，视野范围超出边界宽度时拒绝并返回错误
+func (s *ShardedAOI) Refresh(entity Entity) error {
+	if err := s.checkViewRange(entity); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pos, ok := s.positions[entity.ID()]
+	if !ok {
+		return nil
+	}
+	for shard := range s.shardsNear(pos) {
+		mgr := shard.mgr
+		shard.server.Go(func(AOI) {
+			mgr.Refresh(entity)
+		})
+	}
+	return nil
+}
+
+// 获取视野内的实体，同步执行，分片执行出错时返回错误
+func (s *ShardedAOI) Watching(entity Entity) ([]Entity, error) {
+	s.mutex.Lock()
+	pos, ok := s.positions[entity.ID()]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return s.shardOf(pos).server.Watching(entity)
+}
+
+// 获取能看到实体的观察者，同步执行，汇总实体及其镜像所在的分片
+func (s *ShardedAOI) Watchers(entity Entity) ([]Entity, error) {
+	s.mutex.Lock()
+	pos, ok := s.positions[entity.ID()]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	var list []Entity
+	for shard := range s.shardsNear(pos) {
+		watchers, err := shard.server.Watchers(entity)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, watchers...)
+	}
+	return list, nil
+}
+
+// 不回调的通知，用于交接时静默计算视野
+type silentNotifier struct {
+	*AOIManager
+}
+
+func (silentNotifier) notifyEnter(w, n *aoiNode) {}
+
+func (silentNotifier) notifyLeave(w, n *aoiNode) {}
+
+func (silentNotifier) notifyMove(w, n *aoiNode, from, to Position) {}
+
+// 以镜像进入
+func (m *AOIManager) enterGhost(entity Entity, pos Position, reason Reason) {
+	n := newAOINode(&Ghost{Entity: entity})
+	n.mode = ModeWatched
+	n.ghost = true
+	m.reason = reason
+	m.add(n, pos)
+}
+
+// 镜像离开
+func (m *AOIManager) leaveGhost(entity Entity, reason Reason) {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	m.reason = reason
+	m.remove(n)
+}
+
+// 实体降为镜像，不回调，返回原来视野内实体的镜像副本，交给新分片在其协程中使用
+func (m *AOIManager) demote(entity Entity) map[int]Entity {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return nil
+	}
+	watching := make(map[int]Entity, len(n.watching))
+	for id, other := range n.watching {
+		watching[id] = &Ghost{Entity: realEntity(other.entity), pos: other.pos}
+		delete(other.watchers, entity.ID())
+	}
+	n.watching = make(map[int]*aoiNode)
+	m.setWatchGrids(n, 0, -1, 0, -1)
+	n.entity = &Ghost{Entity: entity, pos: n.pos}
+	n.mode = ModeWatched
+	n.ghost = true
+	return watching
+}
+
+// 镜像升为实体，按原来视野内的实体计算视野变化并回调
+func (m *AOIManager) promote(entity Entity, watching map[int]Entity) {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	n.entity = entity
+	n.mode = ModeBoth
+	n.ghost = false
+	entity.SetPos(n.pos)
+	// 原来视野内的实体按已在视野内判断，使离开视野的缓冲距离在交接后仍然有效
+	for id := range watching {
+		if other, ok := m.nodes[id]; ok && other.mode&ModeWatched != 0 {
+			n.watching[id] = other
+			other.watchers[entity.ID()] = n
+		}
+	}
+	n.viewRange = m.getViewRange(entity)
+	xmin, xmax, ymin, ymax := m.getWatchGrids(n.pos, n.viewRange)
+	m.setWatchGrids(n, xmin, xmax, ymin, ymax)
+	n.updateWatching(silentNotifier{m}, func(f func(*aoiNode)) {
+		m.visitCandidates(n, f)
+	})
+	// 回调视野变化
+	for id, other := range watching {
+		if _, ok := n.watching[id]; !ok {
+			m.callLeave(entity, other, ReasonMove)
+		}
+	}
+	for id, other := range n.watching {
+		if _, ok := watching[id]; !ok {
+			m.callEnter(entity, other.entity, ReasonMove)
+		}
+	}
+}
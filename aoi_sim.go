@@ -0,0 +1,163 @@
+package aoi
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+/*
+模拟：在AOI实现上生成大量实体，按随机种子做确定性的随机游走，
+每一步后用O(n²)的暴力判断逐对检验视野，并统计移动产生的回调次数和每次移动的耗时。
+用于修改Move等实现后回归检验，以及比较不同的AOI实现；不设置Oracle时可用于基准测试。
+*/
+
+// 判断观察者在from时能否看到to处的实体，作为检验视野的标准
+type SimOracle func(from, to Position) bool
+
+// 按视野半径判断
+func RadiusOracle(radius float32) SimOracle {
+	return func(from, to Position) bool {
+		return distSq(from, to) <= radius*radius
+	}
+}
+
+// 按管理的九宫格判断，视野范围为默认值
+func GridOracle(m *AOIManager) SimOracle {
+	return func(from, to Position) bool {
+		xmin, xmax, ymin, ymax := m.getWatchGrids(from, m.getViewRange(nil))
+		x, y := m.transXY(to.x, to.y)
+		return x >= xmin && x <= xmax && y >= ymin && y <= ymax
+	}
+}
+
+// 模拟配置
+type SimConfig struct {
+	Entities int       // 实体数量
+	Size     float32   // 地图边长，实体在[0, Size]内活动
+	Steps    int       // 步数，每一步所有实体各移动一次
+	Moves    int       // 移动总次数，不为0时代替Steps，实体轮流移动，最后一步可能只移动部分实体
+	MaxStep  float32   // 每次移动在x、y方向的最大距离，为0时随机跳到任意位置
+	Seed     int64     // 随机种子，相同的种子得到相同的移动序列
+	Oracle   SimOracle // 检验视野的标准，为空时不检验
+
+	// 每一步移动之前回调，step从0开始，如基准测试在第0步前重置计时器
+	OnStep func(step int)
+}
+
+// 模拟结果
+type SimResult struct {
+	Moves   int           // 移动次数
+	Enters  int           // 移动产生的进入视野回调次数
+	Leaves  int           // 移动产生的离开视野回调次数
+	Moved   int           // 视野内移动回调次数
+	Elapsed time.Duration // AOI操作的总耗时，不包括检验
+	NsPerOp int64         // 每次移动的平均耗时
+}
+
+// 模拟实体
+type simEntity struct {
+	id     int
+	pos    Position
+	others map[int]Entity
+	result *SimResult
+}
+
+func (e *simEntity) ID() int {
+	return e.id
+}
+
+func (e *simEntity) GetPos() Position {
+	return e.pos
+}
+
+func (e *simEntity) SetPos(pos Position) {
+	e.pos = pos
+}
+
+func (e *simEntity) OnEnterAOI(other Entity) {
+	e.others[other.ID()] = other
+	e.result.Enters++
+}
+
+func (e *simEntity) OnLeaveAOI(other Entity) {
+	delete(e.others, other.ID())
+	e.result.Leaves++
+}
+
+func (e *simEntity) OnMoveAOI(other Entity, from, to Position) {
+	e.result.Moved++
+}
+
+// 在AOI实现上模拟随机游走，视野与标准不一致时返回错误
+func Simulate(aoi AOI, cfg SimConfig) (*SimResult, error) {
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	randPos := func() Position {
+		return Position{x: rnd.Float32() * cfg.Size, y: rnd.Float32() * cfg.Size}
+	}
+	result := &SimResult{}
+
+	// 进入
+	es := make([]*simEntity, cfg.Entities)
+	start := time.Now()
+	for i := range es {
+		es[i] = &simEntity{id: i, others: make(map[int]Entity), result: result}
+		aoi.Enter(es[i], randPos())
+	}
+	result.Elapsed += time.Since(start)
+	if err := simCheck(es, cfg.Oracle); err != nil {
+		return result, fmt.Errorf("enter: %v", err)
+	}
+	result.Enters = 0
+
+	// 移动
+	total := cfg.Moves
+	if total == 0 {
+		total = cfg.Steps * cfg.Entities
+	}
+	var moveTime time.Duration
+	for step := 0; len(es) > 0 && result.Moves < total; step++ {
+		if cfg.OnStep != nil {
+			cfg.OnStep(step)
+		}
+		for _, e := range es[:min(len(es), total-result.Moves)] {
+			pos := randPos()
+			if cfg.MaxStep > 0 {
+				pos = Position{
+					x: min(max(e.pos.x+(rnd.Float32()*2-1)*cfg.MaxStep, 0), cfg.Size),
+					y: min(max(e.pos.y+(rnd.Float32()*2-1)*cfg.MaxStep, 0), cfg.Size),
+				}
+			}
+			start := time.Now()
+			aoi.Move(e, pos)
+			moveTime += time.Since(start)
+			result.Moves++
+		}
+		if err := simCheck(es, cfg.Oracle); err != nil {
+			return result, fmt.Errorf("step %d: %v", step, err)
+		}
+	}
+	result.Elapsed += moveTime
+	if result.Moves > 0 {
+		result.NsPerOp = moveTime.Nanoseconds() / int64(result.Moves)
+	}
+	return result, nil
+}
+
+// 逐对检验视野
+func simCheck(es []*simEntity, oracle SimOracle) error {
+	if oracle == nil {
+		return nil
+	}
+	for _, e := range es {
+		for _, other := range es {
+			if other == e {
+				continue
+			}
+			if _, ok := e.others[other.id]; ok != oracle(e.pos, other.pos) {
+				return fmt.Errorf("entity %d watch %d: %v", e.id, other.id, ok)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package aoi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+/*
+快照：把地图范围、网格大小、实体的坐标、进入模式和视野序列化为带版本号的JSON，用于场景迁移到其他进程或崩溃后恢复。
+恢复时直接重建网格和视野关系，不回调进出视野；客户端需要重新同步时再对实体调用Resync。
+选项（视野半径、可见性判断等）和区域不在快照中，恢复时需重新传入选项；开启批量事件时应先Flush再保存快照。
+*/
+
+// 快照版本
+const snapshotVersion = 1
+
+// 地图快照
+type sceneSnapshot struct {
+	Version                int
+	MinX, MaxX, MinY, MaxY float32
+	GSize                  float32
+	Entities               []entitySnapshot
+}
+
+// 实体快照
+type entitySnapshot struct {
+	ID       int
+	X, Y, Z  float32
+	Mode     Mode
+	Watching []int `json:",omitempty"`
+}
+
+// 保存快照
+func (m *AOIManager) Snapshot() ([]byte, error) {
+	s := sceneSnapshot{
+		Version:  snapshotVersion,
+		MinX:     m.minX,
+		MaxX:     m.maxX,
+		MinY:     m.minY,
+		MaxY:     m.maxY,
+		GSize:    m.gsize,
+		Entities: make([]entitySnapshot, 0, len(m.nodes)),
+	}
+	for id, n := range m.nodes {
+		es := entitySnapshot{ID: id, X: n.pos.x, Y: n.pos.y, Z: n.pos.z, Mode: n.mode}
+		for other := range n.watching {
+			es.Watching = append(es.Watching, other)
+		}
+		sort.Ints(es.Watching)
+		s.Entities = append(s.Entities, es)
+	}
+	sort.Slice(s.Entities, func(i, j int) bool {
+		return s.Entities[i].ID < s.Entities[j].ID
+	})
+	return json.Marshal(s)
+}
+
+// 从快照恢复，resolve按id获取实体，返回nil时跳过该实体，不回调进出视野
+func RestoreAOIManager(data []byte, resolve func(id int) Entity, opts ...Option) (*AOIManager, error) {
+	var s sceneSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("aoi snapshot version %d not supported", s.Version)
+	}
+	if s.GSize <= 0 {
+		return nil, fmt.Errorf("aoi snapshot gsize %v invalid", s.GSize)
+	}
+	m := NewAOIManager(s.MinX, s.MaxX, s.MinY, s.MaxY, s.GSize, opts...)
+
+	// 重建网格
+	for _, es := range s.Entities {
+		entity := resolve(es.ID)
+		if entity == nil {
+			continue
+		}
+		n := newAOINode(entity)
+		n.mode = es.Mode
+		n.pos = Position{x: es.X, y: es.Y, z: es.Z}
+		entity.SetPos(n.pos)
+		m.nodes[es.ID] = n
+		if n.mode&ModeWatched != 0 {
+			m.posToGrid(n.pos).entitys[es.ID] = n
+		}
+		if n.mode&ModeWatcher != 0 {
+			n.viewRange = m.getViewRange(entity)
+			xmin, xmax, ymin, ymax := m.getWatchGrids(n.pos, n.viewRange)
+			m.setWatchGrids(n, xmin, xmax, ymin, ymax)
+		}
+	}
+
+	// 重建视野关系
+	for _, es := range s.Entities {
+		n, ok := m.nodes[es.ID]
+		if !ok {
+			continue
+		}
+		for _, id := range es.Watching {
+			if other, ok := m.nodes[id]; ok {
+				n.watching[id] = other
+				other.watchers[es.ID] = n
+				m.setTier(n, other)
+			}
+		}
+	}
+	return m, nil
+}
+
+// 重新同步，按ReasonRefresh回调视野内的所有实体进入视野，用于客户端重连或恢复快照后
+func (m *AOIManager) Resync(entity Entity) {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return
+	}
+	for _, other := range n.watching {
+		m.callEnter(entity, other.entity, ReasonRefresh)
+	}
+}
@@ -0,0 +1,151 @@
+package aoi
+
+import (
+	"encoding/csv"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sort"
+	"strconv"
+)
+
+/*
+统计：按网格统计实体和观察者数量，找出最拥挤的网格，并记录上次重置以来的回调次数，
+可导出为CSV或PNG热力图，用于发现玩家聚集的位置和调整网格大小。
+回调次数在实际回调时统计，批量事件中相互抵消的事件、未实现MoveWatcher的观察者的移动不计入。
+*/
+
+// 回调次数
+type aoiCounters struct {
+	enters, leaves, moves int
+}
+
+// 回调进入视野并计数
+func (m *AOIManager) callEnter(w, other Entity, reason Reason) {
+	m.counters.enters++
+	enterAOI(w, other, reason)
+}
+
+// 回调离开视野并计数
+func (m *AOIManager) callLeave(w, other Entity, reason Reason) {
+	m.counters.leaves++
+	leaveAOI(w, other, reason)
+}
+
+// 回调视野内移动并计数，未实现MoveWatcher时不回调
+func (m *AOIManager) callMove(w, other Entity, from, to Position) {
+	if mw, ok := w.(MoveWatcher); ok {
+		m.counters.moves++
+		mw.OnMoveAOI(other, from, to)
+	}
+}
+
+// 网格统计
+type GridStats struct {
+	X, Y     int // 网格坐标
+	Entities int // 网格中的实体数量
+	Watchers int // 观察网格的实体数量
+}
+
+// 地图统计
+type AOIStats struct {
+	XNum, YNum int           // 网格数量
+	Grids      [][]GridStats // 各网格的统计，按[x][y]索引
+	Hottest    []GridStats   // 实体最多的网格，按实体数量从多到少排列
+	Entities   int           // 实体总数
+	Enters     int           // 进入视野回调次数
+	Leaves     int           // 离开视野回调次数
+	Moves      int           // 视野内移动回调次数
+}
+
+// 获取统计，top为最拥挤网格的数量
+func (m *AOIManager) Stats(top int) *AOIStats {
+	s := &AOIStats{
+		XNum:     m.xNum,
+		YNum:     m.yNum,
+		Grids:    make([][]GridStats, m.xNum),
+		Entities: len(m.nodes),
+		Enters:   m.counters.enters,
+		Leaves:   m.counters.leaves,
+		Moves:    m.counters.moves,
+	}
+	var list []GridStats
+	for x := 0; x < m.xNum; x++ {
+		s.Grids[x] = make([]GridStats, m.yNum)
+		for y := 0; y < m.yNum; y++ {
+			g := &m.grids[x][y]
+			gs := GridStats{X: x, Y: y, Entities: len(g.entitys), Watchers: len(g.watchers)}
+			s.Grids[x][y] = gs
+			if gs.Entities > 0 {
+				list = append(list, gs)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Entities != list[j].Entities {
+			return list[i].Entities > list[j].Entities
+		}
+		return list[i].Watchers > list[j].Watchers
+	})
+	if len(list) > top {
+		list = list[:max(top, 0)]
+	}
+	s.Hottest = list
+	return s
+}
+
+// 重置回调次数
+func (m *AOIManager) ResetStats() {
+	m.counters = aoiCounters{}
+}
+
+// 导出CSV，每行一个网格：x,y,entities,watchers
+func (s *AOIStats) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"x", "y", "entities", "watchers"})
+	for _, col := range s.Grids {
+		for _, gs := range col {
+			cw.Write([]string{
+				strconv.Itoa(gs.X),
+				strconv.Itoa(gs.Y),
+				strconv.Itoa(gs.Entities),
+				strconv.Itoa(gs.Watchers),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 导出PNG热力图，每个网格scale×scale像素，y轴向上，实体越多颜色越接近红色
+func (s *AOIStats) WritePNG(w io.Writer, scale int) error {
+	most := 0
+	for _, col := range s.Grids {
+		for _, gs := range col {
+			most = max(most, gs.Entities)
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, s.XNum*scale, s.YNum*scale))
+	for _, col := range s.Grids {
+		for _, gs := range col {
+			c := heatColor(gs.Entities, most)
+			top := (s.YNum - 1 - gs.Y) * scale
+			for px := gs.X * scale; px < (gs.X+1)*scale; px++ {
+				for py := top; py < top+scale; py++ {
+					img.SetRGBA(px, py, c)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// 热力颜色，空网格为黑色，其余从蓝色渐变到红色
+func heatColor(n, most int) color.RGBA {
+	if n == 0 {
+		return color.RGBA{A: 255}
+	}
+	t := float32(n) / float32(most)
+	return color.RGBA{R: uint8(255 * t), B: uint8(255 * (1 - t)), A: 255}
+}
@@ -0,0 +1,1230 @@
+package aoi
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+type myEntity struct {
+	id     int
+	pos    Position
+	vrange float32        // 视野范围，为0时使用默认值
+	others map[int]Entity // 视野内的实体
+	moved  int            // 收到的移动通知数量
+}
+
+func newMyEntity(id int) *myEntity {
+	return &myEntity{id: id, others: make(map[int]Entity)}
+}
+
+func (e *myEntity) ID() int {
+	return e.id
+}
+
+func (e *myEntity) GetPos() Position {
+	return e.pos
+}
+
+func (e *myEntity) SetPos(pos Position) {
+	e.pos = pos
+}
+
+func (e *myEntity) ViewRange() float32 {
+	return e.vrange
+}
+
+func (e *myEntity) OnEnterAOI(other Entity) {
+	e.others[other.ID()] = other
+}
+
+func (e *myEntity) OnLeaveAOI(other Entity) {
+	delete(e.others, other.ID())
+}
+
+func (e *myEntity) OnMoveAOI(other Entity, from, to Position) {
+	if _, ok := e.others[other.ID()]; !ok || other.GetPos() != to {
+		panic("move out of view")
+	}
+	e.moved++
+}
+
+func randPos(size float32) Position {
+	return Position{x: rand.Float32() * size, y: rand.Float32() * size}
+}
+
+// 检验每个实体的视野与可见性判断一致
+func checkAOI(t *testing.T, es []*myEntity, visible func(e, other *myEntity) bool) {
+	for _, e := range es {
+		for _, other := range es {
+			if other == e {
+				continue
+			}
+			_, ok := e.others[other.id]
+			if ok != visible(e, other) {
+				t.Fatalf("entity %d watch %d: %v", e.id, other.id, ok)
+			}
+		}
+	}
+}
+
+// 九宫格可见性
+func gridVisible(m *AOIManager) func(e, other *myEntity) bool {
+	return func(e, other *myEntity) bool {
+		xmin, xmax, ymin, ymax := m.getWatchGrids(e.pos, m.getViewRange(e))
+		x, y := m.transXY(other.pos.x, other.pos.y)
+		return x >= xmin && x <= xmax && y >= ymin && y <= ymax
+	}
+}
+
+// 视野半径可见性
+func radiusVisible(radius float32) func(e, other *myEntity) bool {
+	return func(e, other *myEntity) bool {
+		r := radius
+		if e.vrange > 0 {
+			r = e.vrange
+		}
+		dx, dy := e.pos.x-other.pos.x, e.pos.y-other.pos.y
+		return dx*dx+dy*dy <= r*r
+	}
+}
+
+// 随机进入、移动、离开，检验视野
+func testAOI(t *testing.T, m AOI, size float32, visible func(e, other *myEntity) bool, ranges ...float32) {
+	// 进入
+	es := make([]*myEntity, 100)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		if len(ranges) > 0 {
+			es[i].vrange = ranges[rand.Intn(len(ranges))]
+		}
+		m.Enter(es[i], randPos(size))
+	}
+	checkAOI(t, es, visible)
+	// 移动
+	for i := 0; i < 1000; i++ {
+		e := es[rand.Intn(len(es))]
+		// 移动前后都能看到实体的观察者，收到一次移动通知
+		seen := make(map[*myEntity]int)
+		for _, other := range es {
+			if _, ok := other.others[e.id]; ok {
+				seen[other] = other.moved
+			}
+		}
+		m.Move(e, randPos(size))
+		checkAOI(t, es, visible)
+		for other, moved := range seen {
+			if _, ok := other.others[e.id]; ok && other.moved != moved+1 {
+				t.Fatalf("entity %d miss move of %d", other.id, e.id)
+			}
+		}
+	}
+	// 查询
+	for _, e := range es {
+		if len(m.Watching(e)) != len(e.others) {
+			t.Fatalf("entity %d watching: %d, want %d", e.id, len(m.Watching(e)), len(e.others))
+		}
+	}
+	// 离开
+	for _, e := range es[:50] {
+		m.Leave(e)
+		if len(e.others) != 0 {
+			t.Fatalf("entity %d still watch %d entities", e.id, len(e.others))
+		}
+	}
+	checkAOI(t, es[50:], visible)
+}
+
+func TestAOIManager(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10)
+	testAOI(t, m, 100, gridVisible(m))
+}
+
+func TestAOIManagerRadius(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15))
+	testAOI(t, m, 100, radiusVisible(15))
+}
+
+func TestAOIManagerViewRange(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10)
+	testAOI(t, m, 100, gridVisible(m), 0, 5, 25)
+	m = NewAOIManager(0, 100, 0, 100, 10, WithRadius(15))
+	testAOI(t, m, 100, radiusVisible(15), 0, 5, 25)
+}
+
+func TestCrossListAOI(t *testing.T) {
+	testAOI(t, NewCrossListAOI(15), 100, radiusVisible(15), 0, 5, 25)
+}
+
+func TestQuadTreeAOI(t *testing.T) {
+	testAOI(t, NewQuadTreeAOI(0, 100, 0, 100, 15, 4), 100, radiusVisible(15), 0, 5, 25)
+
+	// 容量小于1时按1处理，一个实体不分裂
+	q := NewQuadTreeAOI(0, 100, 0, 100, 15, 0)
+	q.Enter(newMyEntity(1), NewPosition(50, 50))
+	if q.root.children != nil {
+		t.Fatal("quadtree capacity 0 split")
+	}
+}
+
+func TestAOIManagerQuery(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10)
+	es := make([]*myEntity, 200)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		m.Enter(es[i], randPos(100))
+	}
+	for i := 0; i < 100; i++ {
+		pos := randPos(100)
+		// 矩形
+		rect := m.QueryRect(pos.x-15, pos.x+15, pos.y-10, pos.y+10)
+		n := 0
+		for _, e := range es {
+			if e.pos.x >= pos.x-15 && e.pos.x <= pos.x+15 && e.pos.y >= pos.y-10 && e.pos.y <= pos.y+10 {
+				n++
+			}
+		}
+		if len(rect) != n {
+			t.Fatalf("query rect: %d, want %d", len(rect), n)
+		}
+		// 圆形
+		circle := m.QueryRadius(pos, 12)
+		n = 0
+		for _, e := range es {
+			if distSq(pos, e.pos) <= 12*12 {
+				n++
+			}
+		}
+		if len(circle) != n {
+			t.Fatalf("query radius: %d, want %d", len(circle), n)
+		}
+		// 最近的k个偶数id实体
+		even := func(e Entity) bool { return e.ID()%2 == 0 }
+		nearest := m.Nearest(pos, 5, even)
+		if len(nearest) != 5 {
+			t.Fatalf("nearest: %d, want 5", len(nearest))
+		}
+		for _, e := range es {
+			if even(e) && distSq(pos, e.pos) < distSq(pos, nearest[4].GetPos()) {
+				found := false
+				for _, other := range nearest {
+					found = found || other == Entity(e)
+				}
+				if !found {
+					t.Fatalf("nearest miss entity %d", e.id)
+				}
+			}
+		}
+	}
+}
+
+type myBatchEntity struct {
+	*myEntity
+	t *testing.T
+}
+
+func (e *myBatchEntity) OnAOIBatch(enters, leaves, moves []Entity) {
+	for _, other := range leaves {
+		if _, ok := e.others[other.ID()]; !ok {
+			e.t.Fatalf("entity %d leave %d: not in view", e.id, other.ID())
+		}
+		delete(e.others, other.ID())
+	}
+	for _, other := range enters {
+		if _, ok := e.others[other.ID()]; ok {
+			e.t.Fatalf("entity %d enter %d: already in view", e.id, other.ID())
+		}
+		e.others[other.ID()] = other
+	}
+	for _, other := range moves {
+		if _, ok := e.others[other.ID()]; !ok {
+			e.t.Fatalf("entity %d move %d: not in view", e.id, other.ID())
+		}
+	}
+}
+
+func TestAOIManagerBatch(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15), WithBatch())
+	es := make([]*myEntity, 100)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		if i%2 == 0 {
+			m.Enter(&myBatchEntity{es[i], t}, randPos(100))
+		} else {
+			m.Enter(es[i], randPos(100))
+		}
+	}
+	m.Flush()
+	checkAOI(t, es, radiusVisible(15))
+	// 每帧随机移动多个实体
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 20; j++ {
+			e := es[rand.Intn(len(es))]
+			m.Move(m.nodes[e.id].entity, randPos(100))
+		}
+		m.Flush()
+		checkAOI(t, es, radiusVisible(15))
+	}
+}
+
+func TestAOIManagerHysteresis(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15), WithHysteresis(3))
+	es := make([]*myEntity, 100)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		m.Enter(es[i], randPos(100))
+	}
+	// 视野范围内必须可见，超出缓冲距离必须不可见
+	check := func() {
+		for _, e := range es {
+			for _, other := range es {
+				_, ok := e.others[other.id]
+				dist := distSq(e.pos, other.pos)
+				if other != e && (dist <= 15*15 && !ok || dist > 18*18 && ok) {
+					t.Fatalf("entity %d watch %d: %v", e.id, other.id, ok)
+				}
+			}
+		}
+	}
+	check()
+	for i := 0; i < 1000; i++ {
+		e := es[rand.Intn(len(es))]
+		pos := e.pos
+		pos.x += rand.Float32()*4 - 2
+		pos.y += rand.Float32()*4 - 2
+		m.Move(e, pos)
+		check()
+	}
+
+	// 在边界附近来回移动，不会反复进出视野
+	a, b := newMyEntity(100), newMyEntity(101)
+	m = NewAOIManager(0, 100, 0, 100, 10, WithRadius(15), WithHysteresis(3))
+	m.Enter(a, Position{x: 50, y: 50})
+	m.Enter(b, Position{x: 64, y: 50})
+	for i := 0; i < 10; i++ {
+		m.Move(b, Position{x: float32(66 - i%2*2), y: 50})
+		if _, ok := a.others[b.id]; !ok {
+			t.Fatal("entity leave view inside hysteresis band")
+		}
+	}
+	m.Move(b, Position{x: 69, y: 50})
+	if _, ok := a.others[b.id]; ok {
+		t.Fatal("entity still in view outside hysteresis band")
+	}
+}
+
+func TestAOIManagerCanSee(t *testing.T) {
+	// 隐身的实体只有同队伍的实体能看到
+	stealth := make(map[int]bool)
+	team := func(e Entity) int { return e.ID() % 3 }
+	canSee := func(watcher, target Entity) bool {
+		return !stealth[target.ID()] || team(watcher) == team(target)
+	}
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15), WithCanSee(canSee))
+	visible := func(e, other *myEntity) bool {
+		return radiusVisible(15)(e, other) && canSee(e, other)
+	}
+	es := make([]*myEntity, 100)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		stealth[i] = rand.Intn(4) == 0
+		m.Enter(es[i], randPos(100))
+	}
+	checkAOI(t, es, visible)
+	for i := 0; i < 1000; i++ {
+		e := es[rand.Intn(len(es))]
+		if rand.Intn(2) == 0 {
+			m.Move(e, randPos(100))
+		} else {
+			stealth[e.id] = !stealth[e.id]
+			m.Refresh(e)
+		}
+		checkAOI(t, es, visible)
+	}
+}
+
+func TestAOIManagerCapacity(t *testing.T) {
+	const capacity = 5
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(20), WithCapacity(capacity, nil))
+	visible := radiusVisible(20)
+	es := make([]*myEntity, 200)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		m.Enter(es[i], randPos(100))
+	}
+	check := func() {
+		for _, e := range es {
+			n := 0
+			for _, other := range es {
+				if other != e && visible(e, other) {
+					n++
+				}
+			}
+			if len(e.others) > capacity || len(e.others) < min(n, capacity) {
+				t.Fatalf("entity %d watch %d entities, %d in range", e.id, len(e.others), n)
+			}
+			for _, other := range e.others {
+				if !visible(e, other.(*myEntity)) {
+					t.Fatalf("entity %d watch %d out of range", e.id, other.ID())
+				}
+			}
+		}
+	}
+	// 每个观察者的视野内都是最近的实体
+	nearest := func() {
+		for _, e := range es {
+			var far float32
+			for _, other := range e.others {
+				far = max(far, distSq(e.pos, other.GetPos()))
+			}
+			for _, other := range es {
+				if _, ok := e.others[other.id]; !ok && other != e && distSq(e.pos, other.pos) < far {
+					t.Fatalf("entity %d miss nearer entity %d", e.id, other.id)
+				}
+			}
+		}
+	}
+	check()
+	nearest()
+	for i := 0; i < 1000; i++ {
+		m.Move(es[rand.Intn(len(es))], randPos(100))
+		check()
+		nearest()
+	}
+
+	// 被观察的实体移远后，让出位置给更近的实体
+	m = NewAOIManager(0, 100, 0, 100, 10, WithRadius(30), WithCapacity(1, nil))
+	w, a, b := newMyEntity(1), newMyEntity(2), newMyEntity(3)
+	m.Enter(w, NewPosition(50, 50))
+	m.Enter(a, NewPosition(52, 50))
+	m.Enter(b, NewPosition(60, 50))
+	m.Move(a, NewPosition(75, 50))
+	if _, ok := w.others[b.id]; !ok || len(w.others) != 1 {
+		t.Fatal("capacity after watched entity moves away")
+	}
+}
+
+func TestAOIManagerMode(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15))
+	es := make([]*myEntity, 100)
+	modes := make(map[int]Mode)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		modes[i] = []Mode{ModeWatcher, ModeWatched, ModeBoth}[i%3]
+		m.EnterMode(es[i], randPos(100), modes[i])
+	}
+	visible := func(e, other *myEntity) bool {
+		return modes[e.id]&ModeWatcher != 0 && modes[other.id]&ModeWatched != 0 && radiusVisible(15)(e, other)
+	}
+	checkAOI(t, es, visible)
+	for i := 0; i < 1000; i++ {
+		m.Move(es[rand.Intn(len(es))], randPos(100))
+		checkAOI(t, es, visible)
+	}
+	for _, e := range es[:50] {
+		m.Leave(e)
+		if len(e.others) != 0 {
+			t.Fatalf("entity %d still watch %d entities", e.id, len(e.others))
+		}
+	}
+	checkAOI(t, es[50:], visible)
+}
+
+func TestAOIManagerVertical(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15), WithVerticalRange(5))
+	visible := func(e, other *myEntity) bool {
+		dz := e.pos.z - other.pos.z
+		return dz >= -5 && dz <= 5 && radiusVisible(15)(e, other)
+	}
+	// 随机分布在三层
+	randPos3 := func() Position {
+		pos := randPos(100)
+		pos.z = float32(rand.Intn(3)) * 10
+		return pos
+	}
+	es := make([]*myEntity, 100)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		m.Enter(es[i], randPos3())
+	}
+	checkAOI(t, es, visible)
+	for i := 0; i < 1000; i++ {
+		e := es[rand.Intn(len(es))]
+		// 原地换层或随机移动
+		pos := e.pos
+		if rand.Intn(2) == 0 {
+			pos.z = float32(rand.Intn(3)) * 10
+		} else {
+			pos = randPos3()
+		}
+		m.Move(e, pos)
+		checkAOI(t, es, visible)
+	}
+}
+
+func TestAOIManagerBounds(t *testing.T) {
+	var rejected []Position
+	m := NewAOIManager(0, 100, 0, 100, 10, WithBoundsCheck(func(entity Entity, pos Position) {
+		rejected = append(rejected, pos)
+	}))
+	a, b := newMyEntity(1), newMyEntity(2)
+	m.Enter(a, NewPosition(99, 99))
+	m.Enter(b, NewPosition(101, 99))
+	if len(rejected) != 1 || len(m.Watching(a)) != 0 {
+		t.Fatal("enter out of bounds")
+	}
+	m.Enter(b, NewPosition(95, 95))
+	m.Move(a, NewPosition3D(120, 99, 5))
+	if len(rejected) != 2 || a.GetPos() != NewPosition(99, 99) || len(b.others) != 1 {
+		t.Fatal("move out of bounds")
+	}
+	if pos := a.GetPos(); pos.X() != 99 || pos.Y() != 99 || pos.Z() != 0 {
+		t.Fatal("position accessors")
+	}
+}
+
+type myReasonEntity struct {
+	*myEntity
+	reasons map[Reason]int // 各原因的回调次数
+}
+
+func (e *myReasonEntity) OnEnterAOIReason(other Entity, reason Reason) {
+	e.OnEnterAOI(other)
+	e.reasons[reason]++
+}
+
+func (e *myReasonEntity) OnLeaveAOIReason(other Entity, reason Reason) {
+	e.OnLeaveAOI(other)
+	e.reasons[reason]++
+}
+
+func TestAOIManagerTeleport(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15))
+	a := &myReasonEntity{newMyEntity(1), make(map[Reason]int)}
+	b := &myReasonEntity{newMyEntity(2), make(map[Reason]int)}
+	m.Enter(a, NewPosition(50, 50))
+	m.Enter(b, NewPosition(55, 50))
+	if a.reasons[ReasonEnter] != 1 || b.reasons[ReasonEnter] != 1 {
+		t.Fatal("enter reason")
+	}
+	// 传送后仍在视野内，双方都收到传送原因的离开和进入
+	m.Teleport(b, NewPosition(45, 50))
+	if a.reasons[ReasonTeleport] != 2 || b.reasons[ReasonTeleport] != 2 || len(a.others) != 1 || len(b.others) != 1 {
+		t.Fatal("teleport in view")
+	}
+	// 传送到远处
+	m.Teleport(b, NewPosition(90, 90))
+	if a.reasons[ReasonTeleport] != 3 || len(a.others) != 0 || len(b.others) != 0 {
+		t.Fatal("teleport out of view")
+	}
+	m.Leave(b)
+	if a.reasons[ReasonLeave] != 0 {
+		t.Fatal("leave reason")
+	}
+
+	// 批量事件中传送后仍在视野内，不合并为移动
+	m = NewAOIManager(0, 100, 0, 100, 10, WithRadius(15), WithBatch())
+	a = &myReasonEntity{newMyEntity(1), make(map[Reason]int)}
+	b = &myReasonEntity{newMyEntity(2), make(map[Reason]int)}
+	m.Enter(a, NewPosition(50, 50))
+	m.Enter(b, NewPosition(55, 50))
+	m.Flush()
+	m.Teleport(b, NewPosition(45, 50))
+	m.Flush()
+	if a.reasons[ReasonTeleport] != 2 || a.moved != 0 || len(a.others) != 1 {
+		t.Fatal("batch teleport in view")
+	}
+}
+
+type myRegionEntity struct {
+	*myEntity
+	regions map[int]bool // 所在的区域
+	entered int          // 进入区域的次数
+}
+
+func (e *myRegionEntity) OnRegionEnter(r *Region) {
+	e.regions[r.ID()] = true
+	e.entered++
+}
+
+func (e *myRegionEntity) OnRegionExit(r *Region) {
+	delete(e.regions, r.ID())
+}
+
+func TestAOIManagerRegion(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10)
+	es := make([]*myRegionEntity, 100)
+	for i := range es {
+		es[i] = &myRegionEntity{myEntity: newMyEntity(i), regions: make(map[int]bool)}
+		m.Enter(es[i], randPos(100))
+	}
+	shapes := map[int]Shape{
+		1: Rect{10, 40, 10, 30},
+		2: Polygon{NewPosition(50, 50), NewPosition(90, 60), NewPosition(60, 95)},
+		3: Rect{0, 100, 45, 55},
+	}
+	for id, shape := range shapes {
+		m.AddRegion(id, shape)
+	}
+	check := func() {
+		for _, e := range es {
+			for id, shape := range shapes {
+				if e.regions[id] != shape.Contains(e.pos) {
+					t.Fatalf("entity %d in region %d: %v", e.id, id, e.regions[id])
+				}
+			}
+		}
+	}
+	check()
+	for i := 0; i < 1000; i++ {
+		m.Move(es[rand.Intn(len(es))], randPos(100))
+		check()
+	}
+	// 在区域内传送，不重复触发进入
+	e := es[0]
+	m.Teleport(e, NewPosition(15, 15))
+	entered := e.entered
+	m.Teleport(e, NewPosition(35, 25))
+	if !e.regions[1] || e.entered != entered {
+		t.Fatal("teleport inside region")
+	}
+	m.Teleport(e, NewPosition(5, 5))
+	check()
+	m.RemoveRegion(3)
+	delete(shapes, 3)
+	check()
+	for _, e := range es {
+		m.Leave(e)
+		if len(e.regions) != 0 {
+			t.Fatalf("entity %d still in regions after leave", e.id)
+		}
+	}
+}
+
+func TestAOIManagerBlockMap(t *testing.T) {
+	// x=50处有一堵墙，中间留一个门
+	b := NewBlockMap(0, 100, 0, 100, 1)
+	for y := 0; y < 100; y++ {
+		if y < 48 || y > 52 {
+			b.SetBlocked(50, y, true)
+		}
+	}
+	if b.LineOfSight(NewPosition(45, 20), NewPosition(55, 20)) || !b.LineOfSight(NewPosition(45, 50), NewPosition(55, 50)) {
+		t.Fatal("line of sight")
+	}
+
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15), WithBlockMap(b))
+	visible := func(e, other *myEntity) bool {
+		return radiusVisible(15)(e, other) && b.LineOfSight(e.pos, other.pos)
+	}
+	es := make([]*myEntity, 100)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		m.Enter(es[i], randPos(100))
+	}
+	checkAOI(t, es, visible)
+	for i := 0; i < 1000; i++ {
+		m.Move(es[rand.Intn(len(es))], randPos(100))
+		checkAOI(t, es, visible)
+	}
+}
+
+type myTierEntity struct {
+	*myEntity
+	m     *AOIManager
+	tiers map[int]Tier // 视野内实体的层级
+}
+
+func (e *myTierEntity) OnEnterAOI(other Entity) {
+	e.myEntity.OnEnterAOI(other)
+	e.tiers[other.ID()], _ = e.m.Tier(e, other)
+}
+
+func (e *myTierEntity) OnLeaveAOI(other Entity) {
+	e.myEntity.OnLeaveAOI(other)
+	delete(e.tiers, other.ID())
+}
+
+func (e *myTierEntity) OnTierChange(other Entity, tier Tier) {
+	if _, ok := e.others[other.ID()]; !ok {
+		panic("tier change out of view")
+	}
+	if e.tiers[other.ID()] == tier {
+		panic("tier not changed")
+	}
+	e.tiers[other.ID()] = tier
+}
+
+func TestAOIManagerTier(t *testing.T) {
+	testAOIManagerTier(t)
+	// 批量事件时层级变化在Flush中进入视野之后回调
+	testAOIManagerTier(t, WithBatch())
+
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(25), WithTiers(0, 1), WithBatch())
+	w := &myTierEntity{newMyEntity(0), m, make(map[int]Tier)}
+	a := &myTierEntity{newMyEntity(1), m, make(map[int]Tier)}
+	b := &myTierEntity{newMyEntity(2), m, make(map[int]Tier)}
+	m.Enter(w, NewPosition(55, 55))
+	m.Enter(b, NewPosition(56, 56))
+	m.Flush()
+	// 同一帧内a进入后移到更远的层级，b移到更远的层级后又回来
+	m.Enter(a, NewPosition(56, 56))
+	m.Move(a, NewPosition(75, 55))
+	m.Move(b, NewPosition(75, 55))
+	m.Move(b, NewPosition(56, 56))
+	if _, ok := w.others[a.id]; ok || w.tiers[b.id] != TierNear {
+		t.Fatal("tier change before flush")
+	}
+	m.Flush()
+	if w.tiers[a.id] != TierFar || w.tiers[b.id] != TierNear {
+		t.Fatalf("batch tiers: %v", w.tiers)
+	}
+	m.Move(b, NewPosition(65, 55))
+	m.Flush()
+	if w.tiers[b.id] != TierMid {
+		t.Fatalf("batch tier change: %v", w.tiers)
+	}
+}
+
+func testAOIManagerTier(t *testing.T, opts ...Option) {
+	m := NewAOIManager(0, 100, 0, 100, 10, append([]Option{WithRadius(25), WithTiers(0, 1)}, opts...)...)
+	es := make([]*myTierEntity, 100)
+	for i := range es {
+		es[i] = &myTierEntity{newMyEntity(i), m, make(map[int]Tier)}
+		m.Enter(es[i], randPos(100))
+	}
+	m.Flush()
+	check := func() {
+		for _, e := range es {
+			if len(e.tiers) != len(e.others) {
+				t.Fatalf("entity %d tiers: %d, want %d", e.id, len(e.tiers), len(e.others))
+			}
+			ex, ey := m.transXY(e.pos.x, e.pos.y)
+			for id, tier := range e.tiers {
+				pos := e.others[id].GetPos()
+				x, y := m.transXY(pos.x, pos.y)
+				want := Tier(min(max(absInt(x-ex), absInt(y-ey)), 2))
+				if tier != want {
+					t.Fatalf("entity %d tier of %d: %d, want %d", e.id, id, tier, want)
+				}
+			}
+		}
+	}
+	check()
+	for i := 0; i < 1000; i++ {
+		m.Move(es[rand.Intn(len(es))], randPos(100))
+		m.Move(es[rand.Intn(len(es))], randPos(100))
+		m.Flush()
+		check()
+	}
+	e := es[0]
+	n := 0
+	for tier := TierNear; tier <= TierFar; tier++ {
+		n += len(m.WatchingTier(e, tier))
+	}
+	if n != len(e.others) {
+		t.Fatalf("watching tier: %d, want %d", n, len(e.others))
+	}
+}
+
+// 调用ID时panic的实体
+type badEntity struct {
+	Entity
+}
+
+func (badEntity) ID() int {
+	panic("bad entity")
+}
+
+func TestAOIServer(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15))
+	s := NewAOIServer(m, 100)
+	s.Start()
+	// 多个协程并发提交命令，回调都在服务协程中执行
+	es := make([]*myEntity, 100)
+	for i := range es {
+		es[i] = newMyEntity(i)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(es []*myEntity) {
+			defer wg.Done()
+			for _, e := range es {
+				s.Enter(e, randPos(100))
+			}
+			for i := 0; i < 250; i++ {
+				s.Move(es[rand.Intn(len(es))], randPos(100))
+			}
+		}(es[g*25 : g*25+25])
+	}
+	wg.Wait()
+	if err := s.Call(func(aoi AOI) {
+		checkAOI(t, es, radiusVisible(15))
+		aoi.(*AOIManager).Teleport(es[0], NewPosition(50, 50))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := s.Watching(es[0]); err != nil || len(list) != len(es[0].others) {
+		t.Fatal("server watching")
+	}
+	// AOI出错时返回错误，而不是空视野
+	if _, err := s.Watchers(badEntity{}); err == nil {
+		t.Fatal("server watchers error")
+	}
+	if err := s.Call(func(aoi AOI) { panic("boom") }); err == nil {
+		t.Fatal("server call panic")
+	}
+	s.Stop()
+}
+
+// 分片地图的实体，进入视野时读取实体的坐标，检验回调中读取坐标不会跨协程竞争
+type myShardEntity struct {
+	*myEntity
+}
+
+func (e myShardEntity) OnEnterAOI(other Entity) {
+	if pos := other.GetPos(); pos.x < 0 || pos.x > 200 {
+		panic("enter out of map")
+	}
+	e.myEntity.OnEnterAOI(other)
+}
+
+// 检验视野与单个管理相同
+func checkSame(t *testing.T, es, want []*myEntity) {
+	for i, e := range es {
+		if len(e.others) != len(want[i].others) {
+			t.Fatalf("entity %d watching: %d, want %d", e.id, len(e.others), len(want[i].others))
+		}
+		for id := range want[i].others {
+			if _, ok := e.others[id]; !ok {
+				t.Fatalf("entity %d miss %d", e.id, id)
+			}
+		}
+	}
+}
+
+func TestShardedAOI(t *testing.T) {
+	// 边界宽度不足、不支持的选项
+	if _, err := NewShardedAOI(0, 200, 0, 200, 10, 3, 3, 10, WithRadius(15)); err == nil {
+		t.Fatal("sharded aoi border")
+	}
+	if _, err := NewShardedAOI(0, 200, 0, 200, 10, 3, 3, 20, WithRadius(15), WithBatch()); err == nil {
+		t.Fatal("sharded aoi batch")
+	}
+	// 隐身的实体，只在分片空闲时修改
+	hidden := make(map[int]bool)
+	canSee := WithCanSee(func(watcher, target Entity) bool {
+		return !hidden[target.ID()]
+	})
+	s, err := NewShardedAOI(0, 200, 0, 200, 10, 3, 3, 18, WithRadius(15), WithHysteresis(3), canSee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewAOIManager(0, 200, 0, 200, 10, WithRadius(15), WithHysteresis(3), canSee)
+	s.Start()
+	es := make([]*myEntity, 200)
+	want := make([]*myEntity, len(es))
+	for i := range es {
+		es[i], want[i] = newMyEntity(i), newMyEntity(i)
+		es[i].vrange = []float32{10, 15}[rand.Intn(2)]
+		want[i].vrange = es[i].vrange
+		p := randPos(200)
+		if err := s.Enter(myShardEntity{es[i]}, p); err != nil {
+			t.Fatal(err)
+		}
+		m.Enter(want[i], p)
+	}
+	// 视野范围超出边界宽度
+	wide := newMyEntity(len(es))
+	wide.vrange = 40
+	if err := s.Enter(wide, NewPosition(100, 100)); err == nil {
+		t.Fatal("sharded aoi wide view range")
+	}
+	s.Wait()
+	checkSame(t, es, want)
+	// 随机小步移动和跳跃，跨越分片时交接
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 200; i++ {
+			k := rand.Intn(len(es))
+			p := randPos(200)
+			if rand.Intn(4) > 0 {
+				pos := want[k].pos
+				p = Position{x: min(max(pos.x+rand.Float32()*20-10, 0), 200), y: min(max(pos.y+rand.Float32()*20-10, 0), 200)}
+			}
+			if err := s.Move(myShardEntity{es[k]}, p); err != nil {
+				t.Fatal(err)
+			}
+			m.Move(want[k], p)
+		}
+		s.Wait()
+		checkSame(t, es, want)
+	}
+	// 查询
+	for i, e := range es {
+		watching, err := s.Watching(e)
+		if err != nil || len(watching) != len(e.others) {
+			t.Fatalf("entity %d watching: %d, want %d", e.id, len(watching), len(e.others))
+		}
+		watchers, err := s.Watchers(e)
+		if err != nil || len(watchers) != len(m.Watchers(want[i])) {
+			t.Fatalf("entity %d watchers: %d, want %d", e.id, len(watchers), len(m.Watchers(want[i])))
+		}
+	}
+	// 隐身和取消隐身后Refresh，镜像所在分片的观察者也要更新
+	for _, hide := range []bool{true, false} {
+		for k := 0; k < len(es); k += 3 {
+			hidden[k] = hide
+		}
+		for k := 0; k < len(es); k += 3 {
+			if err := s.Refresh(myShardEntity{es[k]}); err != nil {
+				t.Fatal(err)
+			}
+			m.Refresh(want[k])
+		}
+		s.Wait()
+		checkSame(t, es, want)
+	}
+	// 离开
+	for i, e := range es[:100] {
+		s.Leave(e)
+		m.Leave(want[i])
+	}
+	s.Wait()
+	checkSame(t, es, want)
+	s.Stop()
+}
+
+func TestAOIManagerSnapshot(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10, WithRadius(15), WithHysteresis(3))
+	es := make([]*myEntity, 100)
+	for i := range es {
+		es[i] = newMyEntity(i)
+		m.Enter(es[i], randPos(100))
+	}
+	for i := 0; i < 500; i++ {
+		m.Move(es[rand.Intn(len(es))], randPos(100))
+	}
+	data, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 恢复时不回调
+	restored := make([]*myEntity, len(es))
+	r, err := RestoreAOIManager(data, func(id int) Entity {
+		restored[id] = newMyEntity(id)
+		return restored[id]
+	}, WithRadius(15), WithHysteresis(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range restored {
+		if len(e.others) != 0 {
+			t.Fatalf("entity %d restore callback", e.id)
+		}
+		if e.pos != es[i].pos || len(r.Watching(e)) != len(es[i].others) {
+			t.Fatalf("entity %d restore watching: %d, want %d", e.id, len(r.Watching(e)), len(es[i].others))
+		}
+	}
+
+	// 重新同步后视野相同，之后的移动结果也相同
+	for _, e := range restored {
+		r.Resync(e)
+	}
+	checkSame(t, restored, es)
+	for i := 0; i < 500; i++ {
+		k := rand.Intn(len(es))
+		p := randPos(100)
+		m.Move(es[k], p)
+		r.Move(restored[k], p)
+	}
+	checkSame(t, restored, es)
+
+	if _, err := RestoreAOIManager([]byte(`{"Version":0}`), nil); err == nil {
+		t.Fatal("restore version")
+	}
+}
+
+func TestAOIManagerReconfigure(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10)
+	es := make([]*myEntity, 100)
+	rs := make([]*myReasonEntity, len(es))
+	for i := range es {
+		es[i] = newMyEntity(i)
+		rs[i] = &myReasonEntity{es[i], make(map[Reason]int)}
+		m.Enter(rs[i], randPos(100))
+	}
+	before := make([]map[int]Entity, len(es))
+	for i, e := range es {
+		before[i] = make(map[int]Entity)
+		for id, other := range e.others {
+			before[i][id] = other
+		}
+	}
+
+	// 扩大地图并调整网格大小，只回调可见性变化的实体
+	m.Reconfigure(0, 200, 0, 200, 20)
+	checkAOI(t, es, gridVisible(m))
+	for i, e := range es {
+		changed := 0
+		for id := range before[i] {
+			if _, ok := e.others[id]; !ok {
+				changed++
+			}
+		}
+		for id := range e.others {
+			if _, ok := before[i][id]; !ok {
+				changed++
+			}
+		}
+		if rs[i].reasons[ReasonRefresh] != changed {
+			t.Fatalf("entity %d reconfigure callbacks: %d, want %d", e.id, rs[i].reasons[ReasonRefresh], changed)
+		}
+	}
+
+	// 调整后进入、移动到新区域
+	for i := 0; i < 500; i++ {
+		m.Move(rs[rand.Intn(len(rs))], randPos(200))
+	}
+	checkAOI(t, es, gridVisible(m))
+}
+
+// 只实现Entity的实体，不接收移动通知
+type myPlainEntity struct {
+	Entity
+}
+
+func TestAOIManagerStats(t *testing.T) {
+	m := NewAOIManager(0, 100, 0, 100, 10)
+	for i := 0; i < 3; i++ {
+		m.Enter(newMyEntity(i), NewPosition(55, 55))
+	}
+	m.Enter(newMyEntity(3), NewPosition(5, 5))
+	s := m.Stats(1)
+	if s.Entities != 4 || s.Enters != 6 || s.Grids[5][5].Entities != 3 || s.Grids[4][4].Watchers != 3 {
+		t.Fatalf("stats: %+v", s.Grids[5][5])
+	}
+	if len(s.Hottest) != 1 || s.Hottest[0].X != 5 || s.Hottest[0].Y != 5 {
+		t.Fatalf("hottest: %v", s.Hottest)
+	}
+	m.ResetStats()
+	if m.Stats(1).Enters != 0 {
+		t.Fatal("reset stats")
+	}
+	if len(m.Stats(-1).Hottest) != 0 {
+		t.Fatal("stats negative top")
+	}
+
+	// 只统计实际的回调：未实现MoveWatcher的观察者不计移动，批量中抵消的事件不计
+	m = NewAOIManager(0, 100, 0, 100, 10, WithBatch())
+	w, a := myPlainEntity{newMyEntity(1)}, newMyEntity(2)
+	m.Enter(w, NewPosition(55, 55))
+	m.Enter(a, NewPosition(56, 56))
+	m.Flush()
+	m.Move(a, NewPosition(57, 57))
+	m.Move(w, NewPosition(54, 54))
+	b := newMyEntity(3)
+	m.Enter(b, NewPosition(58, 58))
+	m.Leave(b)
+	m.Flush()
+	if st := m.Stats(1); st.Enters != 2 || st.Moves != 1 || st.Leaves != 0 {
+		t.Fatalf("stats callbacks: %d enters, %d moves, %d leaves", st.Enters, st.Moves, st.Leaves)
+	}
+	m.Resync(w)
+	if m.Stats(1).Enters != 3 {
+		t.Fatal("stats resync")
+	}
+
+	// 导出
+	var buf bytes.Buffer
+	if err := s.WriteCSV(&buf); err != nil || bytes.Count(buf.Bytes(), []byte("\n")) != 1+s.XNum*s.YNum {
+		t.Fatalf("write csv: %v", err)
+	}
+	buf.Reset()
+	if err := s.WritePNG(&buf, 4); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil || img.Bounds().Dx() != s.XNum*4 || img.Bounds().Dy() != s.YNum*4 {
+		t.Fatalf("write png: %v", err)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	cfg := SimConfig{Entities: 300, Size: 200, Steps: 20, MaxStep: 10, Seed: 1}
+	grid := NewAOIManager(0, 200, 0, 200, 10)
+	impls := []struct {
+		name   string
+		aoi    AOI
+		oracle SimOracle
+	}{
+		{"grid", grid, GridOracle(grid)},
+		{"radius", NewAOIManager(0, 200, 0, 200, 10, WithRadius(15)), RadiusOracle(15)},
+		{"crosslist", NewCrossListAOI(15), RadiusOracle(15)},
+		{"quadtree", NewQuadTreeAOI(0, 200, 0, 200, 15, 8), RadiusOracle(15)},
+	}
+	var results []*SimResult
+	for _, impl := range impls {
+		cfg.Oracle = impl.oracle
+		result, err := Simulate(impl.aoi, cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", impl.name, err)
+		}
+		if result.Moves != cfg.Entities*cfg.Steps || result.Enters == 0 {
+			t.Fatalf("%s: %+v", impl.name, result)
+		}
+		results = append(results, result)
+	}
+	// 相同种子、相同可见性的实现，回调次数相同
+	for _, result := range results[2:] {
+		if result.Enters != results[1].Enters || result.Leaves != results[1].Leaves {
+			t.Fatalf("simulate callbacks: %+v, want %+v", result, results[1])
+		}
+	}
+	// 按移动总次数模拟，每一步前回调
+	var steps []int
+	cfg.Moves, cfg.Oracle = 450, RadiusOracle(15)
+	cfg.OnStep = func(step int) { steps = append(steps, step) }
+	result, err := Simulate(NewCrossListAOI(15), cfg)
+	if err != nil || result.Moves != 450 || len(steps) != 2 || steps[1] != 1 {
+		t.Fatalf("simulate moves: %+v, steps %v, %v", result, steps, err)
+	}
+}
+
+// 基准测试，不计入进入的耗时，每次操作为一次移动
+func benchmarkAOI(b *testing.B, aoi AOI, cfg SimConfig) {
+	cfg.Moves = b.N
+	cfg.OnStep = func(step int) {
+		if step == 0 {
+			b.ResetTimer()
+		}
+	}
+	result, err := Simulate(aoi, cfg)
+	b.StopTimer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(result.Enters+result.Leaves+result.Moved)/float64(result.Moves), "callbacks/move")
+}
+
+// 5000个实体在1000×1000的地图上随机游走
+var benchConfig = SimConfig{Entities: 5000, Size: 1000, MaxStep: 5, Seed: 1}
+
+func BenchmarkAOIManager(b *testing.B) {
+	benchmarkAOI(b, NewAOIManager(0, 1000, 0, 1000, 10), benchConfig)
+}
+
+func BenchmarkAOIManagerRadius(b *testing.B) {
+	benchmarkAOI(b, NewAOIManager(0, 1000, 0, 1000, 10, WithRadius(15)), benchConfig)
+}
+
+func BenchmarkCrossListAOI(b *testing.B) {
+	benchmarkAOI(b, NewCrossListAOI(15), benchConfig)
+}
+
+func BenchmarkQuadTreeAOI(b *testing.B) {
+	benchmarkAOI(b, NewQuadTreeAOI(0, 1000, 0, 1000, 15, 8), benchConfig)
+}
+
+// 密集人群，所有实体在同一网格中，视野有上限
+func BenchmarkAOIManagerCapacity(b *testing.B) {
+	m := NewAOIManager(0, 100, 0, 100, 100, WithRadius(50), WithCapacity(50, nil))
+	benchmarkAOI(b, m, SimConfig{Entities: 1000, Size: 100, MaxStep: 5, Seed: 1})
+}
+
+// 路径长度
+func pathLength(from Position, path []Position) float32 {
+	var length float32
+	for _, pos := range path {
+		length += float32(math.Sqrt(float64(distSq(from, pos))))
+		from = pos
+	}
+	return length
+}
+
+func TestNavGrid(t *testing.T) {
+	m := NewAOIManager(0, 400, 0, 400, 10)
+	g := m.NewNavGrid(0)
+	if cx, cy := g.Cell(NewPosition(55, 35)); cx != 5 || cy != 3 {
+		t.Fatal("nav cell")
+	}
+	// 随机阻挡，JPS与A*的路径长度相同
+	for i := 0; i < 400; i++ {
+		g.SetWalkable(rand.Intn(41), rand.Intn(41), false)
+	}
+	for i := 0; i < 100; i++ {
+		from, to := randPos(400), randPos(400)
+		path, jps := g.FindPath(from, to), g.FindPathJPS(from, to)
+		if (path == nil) != (jps == nil) {
+			t.Fatalf("path %v to %v: %d, jps %d", from, to, len(path), len(jps))
+		}
+		if path == nil {
+			continue
+		}
+		// 相邻路点在相邻格子中
+		cx, cy := g.Cell(from)
+		for _, pos := range path {
+			nx, ny := g.Cell(pos)
+			if absInt(nx-cx) > 1 || absInt(ny-cy) > 1 || !g.canStep(cx, cy, nx-cx, ny-cy) {
+				t.Fatalf("path step (%d, %d) to (%d, %d)", cx, cy, nx, ny)
+			}
+			cx, cy = nx, ny
+		}
+		// 按格子中心比较长度，终点也换为格子中心
+		start, goal := g.Center(g.Cell(from)), g.Center(g.Cell(to))
+		d := pathLength(start, append(path[:len(path)-1:len(path)-1], goal)) - pathLength(start, append(jps[:len(jps)-1:len(jps)-1], goal))
+		if d > 0.1 || d < -0.1 {
+			t.Fatalf("jps length differs by %v", d)
+		}
+		// 平滑后每段都能直线行走，且不会更长
+		smoothed := g.SmoothPath(from, path)
+		prev := from
+		for _, pos := range smoothed {
+			if !g.LineWalkable(prev, pos) {
+				t.Fatalf("smoothed segment %v to %v blocked", prev, pos)
+			}
+			prev = pos
+		}
+		if pathLength(from, smoothed) > pathLength(from, path)+0.01 {
+			t.Fatal("smoothed path longer")
+		}
+	}
+
+	// 代价高的格子绕行
+	g = NewNavGrid(0, 100, 0, 100, 10)
+	for y := 0; y < 10; y++ {
+		g.SetCost(5, y, 100)
+	}
+	// 有代价不同的格子时，跳点搜索和平滑后的路径也要绕行
+	path := g.FindPath(NewPosition(15, 15), NewPosition(85, 15))
+	for _, path := range [][]Position{
+		path,
+		g.FindPathJPS(NewPosition(15, 15), NewPosition(85, 15)),
+		g.SmoothPath(NewPosition(15, 15), path),
+	} {
+		if len(path) == 0 {
+			t.Fatal("no path around costly cells")
+		}
+		// 路点之间按直线采样，跳点搜索的路点不一定落在代价高的格子上
+		prev := NewPosition(15, 15)
+		for _, pos := range path {
+			for i := 0; i <= 100; i++ {
+				k := float32(i) / 100
+				p := NewPosition(prev.x+(pos.x-prev.x)*k, prev.y+(pos.y-prev.y)*k)
+				if cx, cy := g.Cell(p); cx == 5 && cy != 10 {
+					t.Fatalf("path through costly cell %v", p)
+				}
+			}
+			prev = pos
+		}
+	}
+	// 终点不能行走
+	g.SetWalkable(8, 1, false)
+	if g.FindPath(NewPosition(15, 15), g.Center(8, 1)) != nil {
+		t.Fatal("path to blocked cell")
+	}
+}
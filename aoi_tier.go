@@ -0,0 +1,106 @@
+package aoi
+
+/*
+层级：按观察者与实体所在网格的距离，把视野内的实体分为近、中、远等层级，
+同步层可以每帧同步近处的实体，每隔几帧同步远处的实体。
+实体进入视野时确定层级，之后层级变化时回调OnTierChange；开启批量事件时在Flush中回调。
+*/
+
+// 层级
+type Tier int
+
+const (
+	TierNear Tier = iota // 近
+	TierMid              // 中
+	TierFar              // 远
+)
+
+// 可选接口，视野内实体的层级变化时回调
+type TierWatcher interface {
+	OnTierChange(other Entity, tier Tier)
+}
+
+// 开启层级，bounds为各层级的最大网格距离（按切比雪夫距离，同一网格为0），
+// 超出所有bounds的为最后一级，如WithTiers(0, 1)分为近、中、远三级
+func WithTiers(bounds ...int) Option {
+	return func(m *AOIManager) {
+		m.tierBounds = bounds
+	}
+}
+
+// 获取实体在观察者视野内的层级
+func (m *AOIManager) Tier(watcher, target Entity) (Tier, bool) {
+	w, ok := m.nodes[watcher.ID()]
+	if !ok {
+		return 0, false
+	}
+	tier, ok := w.tiers[target.ID()]
+	return tier, ok
+}
+
+// 获取观察者视野内指定层级的实体
+func (m *AOIManager) WatchingTier(entity Entity, tier Tier) []Entity {
+	n, ok := m.nodes[entity.ID()]
+	if !ok {
+		return nil
+	}
+	var list []Entity
+	for id, t := range n.tiers {
+		if t == tier {
+			list = append(list, n.watching[id].entity)
+		}
+	}
+	return list
+}
+
+// 计算实体对观察者的层级
+func (m *AOIManager) getTier(w, n *aoiNode) Tier {
+	wpos, pos := w.pos, n.pos
+	wx, wy := m.transXY(wpos.x, wpos.y)
+	x, y := m.transXY(pos.x, pos.y)
+	d := max(absInt(x-wx), absInt(y-wy))
+	for i, bound := range m.tierBounds {
+		if d <= bound {
+			return Tier(i)
+		}
+	}
+	return Tier(len(m.tierBounds))
+}
+
+// 设置实体进入视野时的层级
+func (m *AOIManager) setTier(w, n *aoiNode) {
+	if m.tierBounds == nil {
+		return
+	}
+	if w.tiers == nil {
+		w.tiers = make(map[int]Tier)
+	}
+	w.tiers[n.entity.ID()] = m.getTier(w, n)
+}
+
+// 实体移动后，更新与其相关的层级
+func (m *AOIManager) updateTiers(n *aoiNode) {
+	for _, other := range n.watching {
+		m.changeTier(n, other)
+	}
+	for _, w := range n.watchers {
+		m.changeTier(w, n)
+	}
+}
+
+// 层级变化时回调
+func (m *AOIManager) changeTier(w, n *aoiNode) {
+	tier := m.getTier(w, n)
+	if old, ok := w.tiers[n.entity.ID()]; ok && old == tier {
+		return
+	}
+	if m.batch != nil {
+		m.batch.addTier(w, n)
+		w.tiers[n.entity.ID()] = tier
+		return
+	}
+	w.tiers[n.entity.ID()] = tier
+	if tw, ok := w.entity.(TierWatcher); ok {
+		tw.OnTierChange(n.entity, tier)
+	}
+}